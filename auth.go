@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// Auth is the password and TOTP secret used for automatic 'tsh login'. The
+// TOTP parameters come from the otpauth:// URI, entries stored before they
+// were supported leave them empty and get the RFC 6238 defaults.
+type Auth struct {
+	Password  string `json:"password"`
+	Secret    string `json:"secret"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Digits    int    `json:"digits,omitempty"`
+	Period    uint   `json:"period,omitempty"`
+	Issuer    string `json:"issuer,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+
+	// code replaces Secret when the OTP code comes from an external provider.
+	code func(t time.Time) (string, error)
+}
+
+// ParseOTPAuthURL reads the secret and TOTP parameters from an
+// otpauth://totp/... URI.
+func ParseOTPAuthURL(uri string) (Auth, error) {
+	key, err := otp.NewKeyFromURL(uri)
+	if err != nil {
+		return Auth{}, err
+	}
+
+	if key.Type() != "totp" {
+		return Auth{}, fmt.Errorf("unsupported otpauth type %q, only totp is supported", key.Type())
+	}
+
+	if key.Secret() == "" {
+		return Auth{}, errors.New("no secret in url")
+	}
+
+	return Auth{
+		Secret:    key.Secret(),
+		Algorithm: key.Algorithm().String(),
+		Digits:    key.Digits().Length(),
+		Period:    uint(key.Period()),
+		Issuer:    key.Issuer(),
+	}, nil
+}
+
+func (a Auth) OTPOpts() totp.ValidateOpts {
+	opts := totp.ValidateOpts{
+		Period:    a.Period,
+		Digits:    otp.Digits(a.Digits),
+		Algorithm: otp.AlgorithmSHA1,
+	}
+
+	switch strings.ToUpper(a.Algorithm) {
+	case "SHA256":
+		opts.Algorithm = otp.AlgorithmSHA256
+	case "SHA512":
+		opts.Algorithm = otp.AlgorithmSHA512
+	case "MD5":
+		opts.Algorithm = otp.AlgorithmMD5
+	}
+
+	if opts.Period == 0 {
+		opts.Period = 30
+	}
+
+	if opts.Digits == 0 {
+		opts.Digits = otp.DigitsSix
+	}
+
+	return opts
+}
+
+func (a Auth) OTPPeriod() time.Duration {
+	return time.Duration(a.OTPOpts().Period) * time.Second
+}
+
+func (a Auth) Code(t time.Time) (string, error) {
+	if a.code != nil {
+		return a.code(t)
+	}
+
+	return totp.GenerateCodeCustom(a.Secret, t, a.OTPOpts())
+}
+
+// AuthStore keeps the Auth used for automatic 'tsh login' of one profile.
+// Every platform provides its own implementation through NewSystemAuthStore,
+// FileStore is available everywhere as an opt-in fallback.
+type AuthStore interface {
+	Name() string
+	Store(auth Auth) error
+	Get() (*Auth, error)
+	Delete() error
+}
+
+// NewAuthStore returns the store of p selected by name: "file" for
+// FileStore, "system" for the OS keyring and "" for whichever one is in use,
+// preferring a provider configured for the profile, then the encrypted file
+// once it has been created.
+func NewAuthStore(name string, p Profile) (AuthStore, error) {
+	switch name {
+	case "system":
+		return NewSystemAuthStore(p.Key()), nil
+	case "file":
+		return NewFileStore(p.Key())
+	case "":
+		provider, err := GetAuthProvider(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if provider != nil {
+			return ProviderStore{*provider}, nil
+		}
+
+		fileStore, err := NewFileStore(p.Key())
+		if err != nil {
+			return nil, err
+		}
+
+		if fileStore.Exists() {
+			return fileStore, nil
+		}
+
+		systemStore := NewSystemAuthStore(p.Key())
+
+		// tssh only worked with the current tsh profile before auth was
+		// stored per profile, the old entry is not for any other one.
+		if !p.IsCurrent() {
+			return systemStore, nil
+		}
+
+		legacyFileStore, err := NewFileStore("")
+		if err != nil {
+			return nil, err
+		}
+
+		if legacyFileStore.Exists() {
+			return LegacyStore{fileStore, legacyFileStore}, nil
+		}
+
+		return LegacyStore{systemStore, NewSystemAuthStore("")}, nil
+	default:
+		return nil, fmt.Errorf("unknown store %q, expected 'system' or 'file'", name)
+	}
+}
+
+// LegacyStore falls back to the single entry tssh kept before auth was
+// stored per profile, for the current profile only. New entries always go to
+// the profile store.
+type LegacyStore struct {
+	AuthStore
+	legacy AuthStore
+}
+
+func (s LegacyStore) Get() (*Auth, error) {
+	auth, err := s.AuthStore.Get()
+	if err != nil || auth != nil {
+		return auth, err
+	}
+
+	return s.legacy.Get()
+}
+
+func (s LegacyStore) Delete() error {
+	err := s.AuthStore.Delete()
+	legacyErr := s.legacy.Delete()
+
+	if err != nil && legacyErr != nil {
+		return err
+	}
+
+	return nil
+}
+
+func StoreAuth(p Profile, auth Auth) error {
+	store, err := NewAuthStore("", p)
+	if err != nil {
+		return err
+	}
+
+	return store.Store(auth)
+}
+
+func GetAuth(p Profile) (*Auth, error) {
+	store, err := NewAuthStore("", p)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.Get()
+}
+
+func DeleteAuth(p Profile) error {
+	store, err := NewAuthStore("", p)
+	if err != nil {
+		return err
+	}
+
+	return store.Delete()
+}
+
+// PrintAuthInfo describes what is stored in store without revealing any of
+// the values.
+func PrintAuthInfo(store AuthStore, auth *Auth) {
+	fmt.Println("Store:", store.Name())
+
+	if auth == nil {
+		fmt.Println("Nothing stored, run 'tssh login'")
+
+		return
+	}
+
+	stored := func(value string) string {
+		if value == "" {
+			return "not stored"
+		}
+
+		return "stored"
+	}
+
+	fmt.Println("Password:", stored(auth.Password))
+
+	switch {
+	case auth.code != nil:
+		fmt.Println("OTP secret: OTP code command")
+	case auth.Secret == "":
+		fmt.Println("OTP secret: not stored")
+	default:
+		opts := auth.OTPOpts()
+
+		otpInfo := fmt.Sprintf("stored (%s, %d digits, %ds", opts.Algorithm, opts.Digits.Length(), opts.Period)
+		if auth.Issuer != "" {
+			otpInfo += ", issuer " + auth.Issuer
+		}
+
+		fmt.Printf("OTP secret: %s)\n", otpInfo)
+	}
+
+	if auth.UpdatedAt.IsZero() {
+		fmt.Println("Last updated: unknown")
+	} else {
+		fmt.Println("Last updated:", auth.UpdatedAt.Local().Format(time.DateTime))
+	}
+}
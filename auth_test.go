@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// The seeds of the RFC 6238 test vectors, base32 encoded.
+const (
+	rfcSecretSHA1   = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	rfcSecretSHA256 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA"
+	rfcSecretSHA512 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNA"
+)
+
+func TestParseOTPAuthURL(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		want Auth
+		// codes are the expected codes at the given Unix times.
+		codes map[int64]string
+	}{
+		{
+			name: "defaults",
+			uri:  "otpauth://totp/Teleport:alice?secret=" + rfcSecretSHA1 + "&issuer=Teleport",
+			want: Auth{Secret: rfcSecretSHA1, Algorithm: "SHA1", Digits: 6, Period: 30, Issuer: "Teleport"},
+			codes: map[int64]string{
+				59:         "287082",
+				1111111109: "081804",
+			},
+		},
+		{
+			name: "SHA1, 8 digits",
+			uri:  "otpauth://totp/alice?secret=" + rfcSecretSHA1 + "&digits=8",
+			want: Auth{Secret: rfcSecretSHA1, Algorithm: "SHA1", Digits: 8, Period: 30},
+			codes: map[int64]string{
+				59:          "94287082",
+				1234567890:  "89005924",
+				20000000000: "65353130",
+			},
+		},
+		{
+			name: "SHA256, 8 digits",
+			uri:  "otpauth://totp/alice?secret=" + rfcSecretSHA256 + "&algorithm=SHA256&digits=8",
+			want: Auth{Secret: rfcSecretSHA256, Algorithm: "SHA256", Digits: 8, Period: 30},
+			codes: map[int64]string{
+				59:          "46119246",
+				1111111109:  "68084774",
+				20000000000: "77737706",
+			},
+		},
+		{
+			name: "SHA512, 8 digits",
+			uri:  "otpauth://totp/alice?secret=" + rfcSecretSHA512 + "&algorithm=SHA512&digits=8",
+			want: Auth{Secret: rfcSecretSHA512, Algorithm: "SHA512", Digits: 8, Period: 30},
+			codes: map[int64]string{
+				59:         "90693936",
+				1234567890: "93441116",
+			},
+		},
+		{
+			// With a 60 second period, 119 is in the period 59 is in with 30.
+			name: "60 second period",
+			uri:  "otpauth://totp/alice?secret=" + rfcSecretSHA1 + "&digits=8&period=60",
+			want: Auth{Secret: rfcSecretSHA1, Algorithm: "SHA1", Digits: 8, Period: 60},
+			codes: map[int64]string{
+				119: "94287082",
+				59:  "84755224",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := ParseOTPAuthURL(tt.uri)
+			if err != nil {
+				t.Fatalf("ParseOTPAuthURL() error = %v", err)
+			}
+
+			if auth.Secret != tt.want.Secret || auth.Algorithm != tt.want.Algorithm || auth.Digits != tt.want.Digits || auth.Period != tt.want.Period || auth.Issuer != tt.want.Issuer {
+				t.Errorf("ParseOTPAuthURL() = %+v, want %+v", auth, tt.want)
+			}
+
+			for unix, want := range tt.codes {
+				code, err := auth.Code(time.Unix(unix, 0))
+				if err != nil {
+					t.Fatalf("Code() error = %v", err)
+				}
+
+				if code != want {
+					t.Errorf("Code(%d) = %s, want %s", unix, code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseOTPAuthURLErrors(t *testing.T) {
+	for _, uri := range []string{
+		"otpauth://hotp/alice?secret=" + rfcSecretSHA1 + "&counter=1",
+		"otpauth://totp/alice?issuer=Teleport",
+		"https://example.com",
+	} {
+		if _, err := ParseOTPAuthURL(uri); err == nil {
+			t.Errorf("ParseOTPAuthURL(%q) succeeded", uri)
+		}
+	}
+}
+
+// Entries stored before the TOTP parameters were kept get the RFC 6238
+// defaults: SHA1, 6 digits and 30 seconds.
+func TestOTPOptsOfOldEntry(t *testing.T) {
+	auth := Auth{}
+
+	err := json.Unmarshal([]byte(`{"password": "hunter2", "secret": "`+rfcSecretSHA1+`"}`), &auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := auth.OTPOpts()
+	if opts.Algorithm.String() != "SHA1" || opts.Digits.Length() != 6 || opts.Period != 30 {
+		t.Errorf("OTPOpts() = %+v, want SHA1, 6 digits, 30 seconds", opts)
+	}
+
+	if auth.OTPPeriod() != 30*time.Second {
+		t.Errorf("OTPPeriod() = %s", auth.OTPPeriod())
+	}
+
+	code, err := auth.Code(time.Unix(59, 0))
+	if err != nil || code != "287082" {
+		t.Errorf("Code(59) = %s, %v, want 287082", code, err)
+	}
+}
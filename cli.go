@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// version is set with -ldflags "-X main.version=...".
+var version = ""
+
+// Globals are the flags every command takes.
+type Globals struct {
+	Proxy string
+	Where string
+	Debug bool
+}
+
+func (g *Globals) define(fs *flag.FlagSet) {
+	fs.StringVar(&g.Proxy, "proxy", g.Proxy, "use the tsh profile of this Teleport proxy")
+	fs.StringVar(&g.Where, "where", g.Where, "only fetch the servers matching this Teleport predicate expression")
+	fs.BoolVar(&g.Debug, "debug", g.Debug, "write a debug log to the cache dir")
+}
+
+// Profile returns the tsh profile the command works with.
+func (g *Globals) Profile() (Profile, error) {
+	profile, err := LoadProfile(g.Proxy)
+	if err != nil {
+		return Profile{}, errors.New("can't detect profile, run 'tsh login' or pass --proxy")
+	}
+
+	return profile, nil
+}
+
+// Command is a tssh command. Setup defines its flags on fs and returns the
+// function running it with the remaining arguments, a command without Setup
+// only groups its subcommands. Complete lists what can follow args, for shell
+// completion.
+type Command struct {
+	Name     string
+	Args     string
+	Summary  string
+	Setup    func(fs *flag.FlagSet, g *Globals) func(args []string) error
+	Complete func(g *Globals, args []string) []string
+	Commands []*Command
+}
+
+func (c *Command) find(name string) *Command {
+	i := slices.IndexFunc(c.Commands, func(sub *Command) bool {
+		return sub.Name == name
+	})
+	if i < 0 {
+		return nil
+	}
+
+	return c.Commands[i]
+}
+
+// usageError is a command called the wrong way, its usage is printed after
+// the error.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// exitCode ends tssh with the code without printing anything, for failures
+// that were already shown.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
+
+// Execute runs the command args name and returns the exit code: 0 on
+// success, 1 when the command failed and 2 when it was called the wrong way.
+func Execute(root *Command, args []string) int {
+	g := &Globals{}
+	cmd := root
+	path := []string{root.Name}
+
+	for {
+		fs := flag.NewFlagSet(strings.Join(path, " "), flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		g.define(fs)
+
+		var run func(args []string) error
+		if cmd.Setup != nil {
+			run = cmd.Setup(fs, g)
+		}
+
+		rest, err := parseFlags(fs, args, len(cmd.Commands) != 0)
+		if errors.Is(err, flag.ErrHelp) {
+			printUsage(os.Stdout, cmd, fs, path)
+
+			return 0
+		}
+		if err != nil {
+			return fail(cmd, fs, path, usageError{err.Error()})
+		}
+
+		if len(rest) != 0 {
+			if sub := cmd.find(rest[0]); sub != nil {
+				cmd = sub
+				path = append(path, sub.Name)
+				args = rest[1:]
+
+				continue
+			}
+		}
+
+		if run == nil {
+			if len(rest) != 0 {
+				return fail(cmd, fs, path, usagef("unknown command %q", rest[0]))
+			}
+
+			return fail(cmd, fs, path, usagef("missing command"))
+		}
+
+		if len(cmd.Commands) != 0 {
+			rest, err = parseFlags(fs, rest, false)
+			if err != nil {
+				return fail(cmd, fs, path, usageError{err.Error()})
+			}
+		}
+
+		closeLog, err := setupLog(g.Debug)
+		if err != nil {
+			return fail(cmd, fs, path, err)
+		}
+		defer closeLog()
+
+		return fail(cmd, fs, path, run(rest))
+	}
+}
+
+// fail prints err the one way every command reports errors and returns the
+// exit code for it.
+func fail(cmd *Command, fs *flag.FlagSet, path []string, err error) int {
+	var code exitCode
+	var usage usageError
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &code):
+		return int(code)
+	case errors.As(err, &usage):
+		fmt.Fprintf(os.Stderr, "%s: %s\n\n", strings.Join(path, " "), usage.msg)
+		printUsage(os.Stderr, cmd, fs, path)
+
+		return 2
+	}
+
+	fmt.Fprintf(os.Stderr, "%s: %s\n", strings.Join(path, " "), err)
+
+	return 1
+}
+
+// parseFlags parses the flags found anywhere in args and returns the other
+// arguments. Parsing ends at "--", which is kept for the command, and with
+// stop at the first argument that isn't a flag. A "-key=value" that isn't a
+// flag is a negated label filter and kept as an argument.
+func parseFlags(fs *flag.FlagSet, args []string, stop bool) ([]string, error) {
+	rest := []string{}
+
+	for len(args) != 0 {
+		arg := args[0]
+
+		if arg == "--" {
+			return append(rest, args...), nil
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		isFlag := strings.HasPrefix(arg, "-") && arg != "-"
+		if isFlag && fs.Lookup(name) == nil && name != "h" && name != "help" && strings.ContainsAny(arg, "=:") {
+			isFlag = false
+		}
+
+		if !isFlag {
+			if stop {
+				return append(rest, args...), nil
+			}
+
+			rest = append(rest, arg)
+			args = args[1:]
+
+			continue
+		}
+
+		// The flag package would show the help for "-h=x" too.
+		if (name == "h" || name == "help") && hasValue && fs.Lookup(name) == nil {
+			return nil, fmt.Errorf("flag %s takes no value", strings.SplitN(arg, "=", 2)[0])
+		}
+
+		n := 1
+		if f := fs.Lookup(name); f != nil && !hasValue && !isBoolFlag(f) && len(args) > 1 {
+			n = 2
+		}
+
+		err := fs.Parse(args[:n])
+		if err != nil {
+			return nil, err
+		}
+
+		args = args[n:]
+	}
+
+	return rest, nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+
+	return ok && b.IsBoolFlag()
+}
+
+func printUsage(w io.Writer, cmd *Command, fs *flag.FlagSet, path []string) {
+	usage := strings.Join(path, " ")
+	if len(cmd.Commands) != 0 && cmd.Setup == nil {
+		usage += " <command>"
+	}
+	usage += " [flags]"
+	if cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+
+	fmt.Fprintf(w, "Usage: %s\n\n%s\n", usage, cmd.Summary)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+
+	if len(cmd.Commands) != 0 {
+		fmt.Fprintln(tw, "\nCommands:")
+		for _, sub := range cmd.Commands {
+			fmt.Fprintf(tw, "  %s\t%s\n", strings.TrimSpace(sub.Name+" "+sub.Args), sub.Summary)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nFlags:")
+	fs.VisitAll(func(f *flag.Flag) {
+		kind, usage := flag.UnquoteUsage(f)
+		if kind != "" {
+			kind = " " + kind
+		}
+
+		if f.DefValue != "" && f.DefValue != "false" {
+			usage += fmt.Sprintf(" (default %q)", f.DefValue)
+		}
+
+		dashes := "--"
+		if len(f.Name) == 1 {
+			dashes = "-"
+		}
+
+		fmt.Fprintf(tw, "  %s%s%s\t%s\n", dashes, f.Name, kind, usage)
+	})
+
+	tw.Flush()
+}
+
+// suggestCommand returns the command of root name is probably a typo of.
+func suggestCommand(root *Command, name string) string {
+	for _, cmd := range root.Commands {
+		if len(name) >= 3 && editDistance(name, cmd.Name) <= 2 {
+			return cmd.Name
+		}
+	}
+
+	return ""
+}
+
+func editDistance(a string, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			prev, row[j] = row[j], min(row[j]+1, row[j-1]+1, prev+cost)
+		}
+	}
+
+	return row[len(b)]
+}
+
+// setupLog writes the log to debug.log in the cache dir when enabled and drops
+// it otherwise.
+func setupLog(enabled bool) (func(), error) {
+	if !enabled {
+		log.SetOutput(io.Discard)
+
+		return func() {}, nil
+	}
+
+	dir, err := GetCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(dir, os.ModeDir|0755)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := tea.LogToFile(filepath.Join(dir, "debug.log"), "tssh")
+	if err != nil {
+		return nil, err
+	}
+
+	return func() { file.Close() }, nil
+}
+
+// Version is the version tssh was installed as, or "dev".
+func Version() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+
+	return "dev"
+}
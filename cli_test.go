@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		stop    bool
+		rest    []string
+		proxy   string
+		refresh bool
+		wantErr error
+	}{
+		{
+			name: "flags after arguments",
+			args: []string{"web", "--proxy", "teleport.example.com", "--refresh"},
+			rest: []string{"web"}, proxy: "teleport.example.com", refresh: true,
+		},
+		{
+			name: "flag with a value",
+			args: []string{"--proxy=teleport.example.com", "web"},
+			rest: []string{"web"}, proxy: "teleport.example.com",
+		},
+		{
+			name: "label filters",
+			args: []string{"-env=prod", "team:db", "web"},
+			rest: []string{"-env=prod", "team:db", "web"},
+		},
+		{
+			name: "arguments after --",
+			args: []string{"web", "--", "-A", "--proxy", "other"},
+			rest: []string{"web", "--", "-A", "--proxy", "other"},
+		},
+		{
+			name: "stop at the command",
+			args: []string{"--refresh", "ls", "--proxy", "teleport.example.com"},
+			stop: true,
+			rest: []string{"ls", "--proxy", "teleport.example.com"}, refresh: true,
+		},
+		{
+			name:    "help",
+			args:    []string{"web", "--help"},
+			wantErr: flag.ErrHelp,
+		},
+		{
+			name:    "help with a value",
+			args:    []string{"-h=x"},
+			wantErr: errors.New("flag -h takes no value"),
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"--bogus"},
+			wantErr: errors.New("flag provided but not defined: -bogus"),
+		},
+		{
+			name:    "missing value",
+			args:    []string{"--proxy"},
+			wantErr: errors.New("flag needs an argument: -proxy"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("tssh", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			proxy := fs.String("proxy", "", "")
+			refresh := fs.Bool("refresh", false, "")
+
+			rest, err := parseFlags(fs, tt.args, tt.stop)
+
+			if tt.wantErr != nil {
+				if err == nil || (err != tt.wantErr && err.Error() != tt.wantErr.Error()) {
+					t.Fatalf("parseFlags() error = %v, want %v", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseFlags() error = %v", err)
+			}
+
+			if !slices.Equal(rest, tt.rest) || *proxy != tt.proxy || *refresh != tt.refresh {
+				t.Errorf("parseFlags() = %q, proxy %q, refresh %v, want %q, proxy %q, refresh %v", rest, *proxy, *refresh, tt.rest, tt.proxy, tt.refresh)
+			}
+		})
+	}
+}
+
+func TestExecute(t *testing.T) {
+	var ran []string
+
+	run := func(name string, err error) func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+		return func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+			fs.Bool("yes", false, "")
+
+			return func(args []string) error {
+				ran = append([]string{name, g.Proxy}, args...)
+
+				return err
+			}
+		}
+	}
+
+	root := &Command{
+		Name:  "tssh",
+		Setup: run("tssh", nil),
+		Commands: []*Command{
+			{Name: "ls", Setup: run("ls", nil)},
+			{Name: "fail", Setup: run("fail", errors.New("failed"))},
+			{Name: "exit", Setup: run("exit", exitCode(3))},
+			{Name: "usage", Setup: run("usage", usagef("missing host"))},
+			{
+				Name: "cache",
+				Commands: []*Command{
+					{Name: "prune", Setup: run("prune", nil)},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		args []string
+		code int
+		ran  []string
+	}{
+		{args: []string{}, code: 0, ran: []string{"tssh", ""}},
+		{args: []string{"web", "--proxy=teleport.example.com"}, code: 0, ran: []string{"tssh", "teleport.example.com", "web"}},
+		{args: []string{"--proxy", "teleport.example.com", "ls", "web"}, code: 0, ran: []string{"ls", "teleport.example.com", "web"}},
+		{args: []string{"cache", "prune", "--yes"}, code: 0, ran: []string{"prune", ""}},
+		{args: []string{"ls", "--help"}, code: 0},
+		{args: []string{"-h=x"}, code: 2},
+		{args: []string{"ls", "--bogus"}, code: 2},
+		{args: []string{"cache"}, code: 2},
+		{args: []string{"cache", "clear"}, code: 2},
+		{args: []string{"usage"}, code: 2, ran: []string{"usage", ""}},
+		{args: []string{"fail"}, code: 1, ran: []string{"fail", ""}},
+		{args: []string{"exit"}, code: 3, ran: []string{"exit", ""}},
+	}
+
+	for _, tt := range tests {
+		ran = nil
+
+		code := Execute(root, tt.args)
+		if code != tt.code || !slices.Equal(ran, tt.ran) {
+			t.Errorf("Execute(%q) = %d, ran %q, want %d, ran %q", tt.args, code, ran, tt.code, tt.ran)
+		}
+	}
+}
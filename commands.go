@@ -0,0 +1,573 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Firebain/tssh/lists"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// RootCommand returns the tssh command tree.
+func RootCommand() *Command {
+	root := &Command{
+		Name:     "tssh",
+		Args:     "[query]",
+		Summary:  "Search the servers of your Teleport cluster and connect to them with tsh ssh.",
+		Complete: completeHosts,
+	}
+
+	root.Setup = func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+		showVersion := fs.Bool("version", false, "print the version")
+
+		return func(args []string) error {
+			if *showVersion {
+				fmt.Println(Version())
+
+				return nil
+			}
+
+			// A mistyped command would open the list searching for it.
+			if len(args) == 1 {
+				if name := suggestCommand(root, args[0]); name != "" && !matchesCachedServer(g, args[0]) {
+					return usagef("unknown command %q, did you mean 'tssh %s'?", args[0], name)
+				}
+			}
+
+			query, sshArgs := splitSSHPassthrough(args)
+
+			err := runApp(g, query, sshArgs)
+			if errors.Is(err, exitCode(1)) && len(args) != 0 {
+				if name := suggestCommand(root, args[0]); name != "" {
+					fmt.Fprintf(os.Stderr, "Did you mean 'tssh %s'?\n", name)
+				}
+			}
+
+			return err
+		}
+	}
+
+	root.Commands = []*Command{
+		{
+			Name:     "connect",
+			Args:     "<query>",
+			Summary:  "Connect to the server the query names, or search with it",
+			Complete: completeHosts,
+			Setup: func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+				return func(args []string) error {
+					query, sshArgs := splitSSHPassthrough(args)
+					if query == "" {
+						return usagef("missing query")
+					}
+
+					return runApp(g, query, sshArgs)
+				}
+			},
+		},
+		{
+			Name:     "ls",
+			Args:     "[query]",
+			Summary:  "Print the servers matching the query",
+			Setup:    setupLsCommand,
+			Complete: completeHosts,
+		},
+		{
+			Name:    "login",
+			Summary: "Store the password and OTP secret used to log in to tsh",
+			Setup:   setupLoginCommand,
+		},
+		{
+			Name:    "logout",
+			Summary: "Delete the stored password and OTP secret",
+			Setup: func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+				return func(args []string) error {
+					profile, err := profileOf(g, args)
+					if err != nil {
+						return err
+					}
+
+					err = DeleteAuth(profile)
+					if err != nil {
+						return err
+					}
+
+					fmt.Println("Deleted")
+
+					return nil
+				}
+			},
+		},
+		{
+			Name:    "rekey",
+			Summary: "Encrypt the auth file with a new passphrase",
+			Setup: func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+				return func(args []string) error {
+					profile, err := profileOf(g, args)
+					if err != nil {
+						return err
+					}
+
+					return rekey(profile)
+				}
+			},
+		},
+		{
+			Name:    "cache",
+			Summary: "Manage the servers cache",
+			Commands: []*Command{
+				{
+					Name:    "location",
+					Summary: "Print where the servers cache is",
+					Setup: func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+						return func(args []string) error {
+							profile, err := profileOf(g, args)
+							if err != nil {
+								return err
+							}
+
+							location, err := GetCachePath(profile)
+							if err != nil {
+								return err
+							}
+
+							fmt.Println(location)
+
+							return nil
+						}
+					},
+				},
+				{
+					Name:    "prune",
+					Summary: "Delete the cache of every profile",
+					Setup: func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+						yes := false
+						fs.BoolVar(&yes, "y", false, "don't ask for confirmation")
+						fs.BoolVar(&yes, "yes", false, "don't ask for confirmation")
+
+						return func(args []string) error {
+							if len(args) != 0 {
+								return usagef("unexpected argument %q", args[0])
+							}
+
+							if !yes && !confirm("Delete the cached servers of every profile?") {
+								return errors.New("nothing deleted, pass -y to delete without asking")
+							}
+
+							err := DeleteServersInto()
+							if err != nil {
+								return err
+							}
+
+							fmt.Println("Deleted")
+
+							return nil
+						}
+					},
+				},
+			},
+		},
+		{
+			Name:    "history",
+			Summary: "Print the connection history, most recent first",
+			Setup:   setupHistoryCommand(nil),
+			Commands: []*Command{
+				{
+					Name:     "forget",
+					Args:     "<host>",
+					Summary:  "Remove a host from the history",
+					Complete: completeFirst(completeHistory),
+					Setup: setupHistoryCommand(func(history *History, args []string) error {
+						if len(args) != 1 {
+							return usagef("expected one host")
+						}
+
+						if history.Forget(args[0]) == 0 {
+							return fmt.Errorf("%s is not in the history", args[0])
+						}
+
+						return nil
+					}),
+				},
+				{
+					Name:    "clear",
+					Summary: "Remove every host from the history",
+					Setup: setupHistoryCommand(func(history *History, args []string) error {
+						if len(args) != 0 {
+							return usagef("unexpected argument %q", args[0])
+						}
+
+						history.Entries = nil
+
+						return nil
+					}),
+				},
+			},
+		},
+		{
+			Name:    "fav",
+			Summary: "Print the favourite servers",
+			Setup:   setupFavouritesCommand(nil),
+			Commands: []*Command{
+				{
+					Name:    "ls",
+					Summary: "Print the favourite servers",
+					Setup:   setupFavouritesCommand(nil),
+				},
+				{
+					Name:     "add",
+					Args:     "<host> [<alias>]",
+					Summary:  "Pin a server, host is a hostname or <cluster>/<hostname>",
+					Complete: completeFirst(completeHostnames),
+					Setup: setupFavouritesCommand(func(p Profile, favourites *Favourites, args []string) error {
+						if len(args) != 1 && len(args) != 2 {
+							return usagef("expected a host and an optional alias")
+						}
+
+						info, err := GetServersInfoFromCache(p)
+						if err != nil {
+							return errors.New("no cached servers, run 'tssh' first")
+						}
+
+						server, err := ResolveServer(info, args[0])
+						if err != nil {
+							return err
+						}
+
+						alias := ""
+						if len(args) == 2 {
+							alias = args[1]
+						}
+
+						return favourites.Add(server, alias)
+					}),
+				},
+				{
+					Name:     "rm",
+					Args:     "<host|alias>",
+					Summary:  "Unpin a server",
+					Complete: completeFirst(completeFavourites),
+					Setup: setupFavouritesCommand(func(p Profile, favourites *Favourites, args []string) error {
+						if len(args) != 1 {
+							return usagef("expected a host or an alias")
+						}
+
+						if !favourites.Remove(args[0]) {
+							return fmt.Errorf("%s is not a favourite", args[0])
+						}
+
+						return nil
+					}),
+				},
+			},
+		},
+		{
+			Name:    "completion",
+			Args:    "bash|zsh|fish",
+			Summary: "Print the shell completion script",
+			Setup:   setupCompletionCommand,
+			Complete: completeFirst(func(g *Globals) []string {
+				return []string{"bash", "zsh", "fish"}
+			}),
+		},
+		{
+			Name:    "version",
+			Summary: "Print the version",
+			Setup: func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+				return func(args []string) error {
+					fmt.Println(Version())
+
+					return nil
+				}
+			},
+		},
+	}
+
+	root.Commands = append(root.Commands, &Command{
+		Name:    "help",
+		Args:    "[command]",
+		Summary: "Print the usage of a command",
+		Setup: func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+			return func(args []string) error {
+				cmd := root
+				path := []string{root.Name}
+
+				for _, name := range args {
+					cmd = cmd.find(name)
+					if cmd == nil {
+						return usagef("unknown command %q", strings.Join(append(path[1:], name), " "))
+					}
+
+					path = append(path, name)
+				}
+
+				printUsage(os.Stdout, cmd, commandFlags(cmd, g, strings.Join(path, " ")), path)
+
+				return nil
+			}
+		},
+		Complete: func(g *Globals, args []string) []string {
+			cmd := root
+			for _, name := range args {
+				cmd = cmd.find(name)
+				if cmd == nil {
+					return nil
+				}
+			}
+
+			names := []string{}
+			for _, sub := range cmd.Commands {
+				names = append(names, sub.Name)
+			}
+
+			return names
+		},
+	})
+
+	return root
+}
+
+// profileOf returns the profile of a command that takes no arguments.
+func profileOf(g *Globals, args []string) (Profile, error) {
+	if len(args) != 0 {
+		return Profile{}, usagef("unexpected argument %q", args[0])
+	}
+
+	return g.Profile()
+}
+
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// splitSSHPassthrough returns the query and the tsh ssh arguments given after
+// "--".
+func splitSSHPassthrough(args []string) (string, []string) {
+	i := slices.Index(args, "--")
+	if i < 0 {
+		return strings.Join(args, " "), nil
+	}
+
+	return strings.Join(args[:i], " "), args[i+1:]
+}
+
+// runApp starts the servers list, query connects straight away when it names
+// one server. sshArgs are passed to tsh ssh.
+func runApp(g *Globals, query string, sshArgs []string) error {
+	profile, err := g.Profile()
+	if err != nil {
+		return err
+	}
+
+	p := tea.NewProgram(InitAppModel(profile, g.Where, query, sshArgs))
+	result, err := p.Run()
+	if err != nil {
+		return err
+	}
+
+	// The TUI or tsh has shown why it failed already.
+	if m := result.(AppModel); m.exitStatus != 0 {
+		return exitCode(m.exitStatus)
+	} else if m.failed {
+		return exitCode(1)
+	}
+
+	return nil
+}
+
+// matchesCachedServer tells whether query finds any server in the cache of
+// the profile, without fetching.
+func matchesCachedServer(g *Globals, query string) bool {
+	profile, err := g.Profile()
+	if err != nil {
+		return false
+	}
+
+	info, err := GetServersInfoFromCache(profile)
+	if err != nil {
+		return false
+	}
+
+	favourites, err := LoadFavourites(profile)
+	if err != nil {
+		favourites = &Favourites{}
+	}
+
+	return len(lists.Match(ServerRows(info, nil, &History{}, favourites), query)) != 0
+}
+
+func setupLoginCommand(fs *flag.FlagSet, g *Globals) func(args []string) error {
+	storeName := fs.String("store", "", "where to store the auth info: system or file")
+	qrFile := fs.String("qr-file", "", "read the OTP secret from this QR code image")
+	noVerify := fs.Bool("no-verify", false, "store without trying to log in first")
+	show := fs.Bool("show", false, "describe what is stored without revealing it")
+
+	return func(args []string) error {
+		profile, err := profileOf(g, args)
+		if err != nil {
+			return err
+		}
+
+		store, err := NewAuthStore(*storeName, profile)
+		if err != nil {
+			return err
+		}
+
+		auth, err := store.Get()
+		if err != nil {
+			return err
+		}
+
+		if *show {
+			PrintAuthInfo(store, auth)
+
+			return nil
+		}
+
+		m := InitLoginModel(profile, store, !*noVerify, auth)
+		if *qrFile != "" {
+			m.secretInput.SetValue(*qrFile)
+		}
+
+		p := tea.NewProgram(m)
+		result, err := p.Run()
+		if err != nil {
+			return err
+		}
+
+		// Cancelled, or the error was shown in the form.
+		if result.(LoginModel).stage != "success" {
+			return exitCode(1)
+		}
+
+		// Verifying may have been the first login to the proxy.
+		reloadProfile(profile)
+
+		return nil
+	}
+}
+
+func rekey(profile Profile) error {
+	store, err := NewFileStore(profile.Key())
+	if err != nil {
+		return err
+	}
+
+	if !store.Exists() && profile.IsCurrent() {
+		store, err = NewFileStore("")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = store.Rekey(os.Getenv("TSSH_NEW_PASSPHRASE"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Rekeyed")
+
+	return nil
+}
+
+// setupHistoryCommand prints the history, or changes it with change and
+// stores it.
+func setupHistoryCommand(change func(history *History, args []string) error) func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+	return func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+		return func(args []string) error {
+			if change == nil && len(args) != 0 {
+				return usagef("unknown command %q", args[0])
+			}
+
+			profile, err := g.Profile()
+			if err != nil {
+				return err
+			}
+
+			// An old servers cache may still have the recents.
+			info, _ := GetServersInfoFromCache(profile)
+
+			history, err := LoadHistory(profile, info)
+			if err != nil {
+				return err
+			}
+
+			if change == nil {
+				for _, entry := range history.Sorted() {
+					fmt.Printf("%s\t%s@%s\t%d\t%s\t%s\n", entry.LastUsed.Format(time.DateTime), entry.User, entry.Hostname, entry.Count, entry.Cluster, strings.Join(entry.Args, " "))
+				}
+
+				return nil
+			}
+
+			err = change(history, args)
+			if err != nil {
+				return err
+			}
+
+			err = StoreHistory(profile, history)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("Deleted")
+
+			return nil
+		}
+	}
+}
+
+// setupFavouritesCommand prints the favourites, or changes them with change
+// and stores them.
+func setupFavouritesCommand(change func(p Profile, favourites *Favourites, args []string) error) func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+	return func(fs *flag.FlagSet, g *Globals) func(args []string) error {
+		return func(args []string) error {
+			if change == nil && len(args) != 0 {
+				return usagef("unknown command %q", args[0])
+			}
+
+			profile, err := g.Profile()
+			if err != nil {
+				return err
+			}
+
+			favourites, err := LoadFavourites(profile)
+			if err != nil {
+				return err
+			}
+
+			if change == nil {
+				for _, favourite := range favourites.Entries {
+					fmt.Printf("%s\t%s\t%s\n", favourite.Alias, favourite.Hostname, favourite.Cluster)
+				}
+
+				return nil
+			}
+
+			err = change(profile, favourites, args)
+			if err != nil {
+				return err
+			}
+
+			err = StoreFavourites(profile, favourites)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("Saved")
+
+			return nil
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// completeCommand is run by the completion scripts with the words typed
+// after tssh, the last one being completed.
+const completeCommand = "__complete"
+
+const bashCompletion = `_tssh() {
+    local line=${COMP_LINE:0:COMP_POINT}
+    local -a words
+    read -ra words <<< "$line"
+    [[ $line == *[[:space:]] ]] && words+=("")
+
+    # Bash splits words at = and :, only the part after them is replaced.
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    local word=${words[${#words[@]}-1]}
+    local prefix=${word%"$cur"}
+
+    local IFS=$'\n'
+    COMPREPLY=($(tssh __complete "${words[@]:1}" 2>/dev/null))
+    COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+}
+
+complete -F _tssh tssh
+`
+
+const zshCompletion = `#compdef tssh
+
+_tssh() {
+    local -a candidates
+    candidates=(${(f)"$(tssh __complete "${(@)words[2,CURRENT]}" 2>/dev/null)"})
+    compadd -Q -a candidates
+}
+
+if [[ $zsh_eval_context[-1] == loadautofunc ]]; then
+    _tssh "$@"
+else
+    compdef _tssh tssh
+fi
+`
+
+const fishCompletion = `complete -c tssh -f -a '(tssh __complete (commandline -opc)[2..-1] (commandline -ct))'
+`
+
+func setupCompletionCommand(fs *flag.FlagSet, g *Globals) func(args []string) error {
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usagef("expected bash, zsh or fish")
+		}
+
+		switch args[0] {
+		case "bash":
+			fmt.Print(bashCompletion)
+		case "zsh":
+			fmt.Print(zshCompletion)
+		case "fish":
+			fmt.Print(fishCompletion)
+		default:
+			return usagef("unknown shell %q, use bash, zsh or fish", args[0])
+		}
+
+		return nil
+	}
+}
+
+// Complete prints what the last of words can be completed to, one per line.
+// It only reads local files, so completing stays fast on large clusters.
+func Complete(w io.Writer, root *Command, words []string) {
+	current := ""
+	if len(words) != 0 {
+		current = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	g := &Globals{}
+	cmd := root
+	fs := commandFlags(cmd, g, root.Name)
+	args := []string{}
+
+	var pending *flag.Flag
+
+	for _, word := range words {
+		if pending != nil {
+			fs.Set(pending.Name, word)
+			pending = nil
+
+			continue
+		}
+
+		// Everything after -- goes to tsh.
+		if word == "--" {
+			return
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(word, "-"), "=")
+		if strings.HasPrefix(word, "-") && fs.Lookup(name) != nil {
+			switch {
+			case hasValue:
+				fs.Set(name, value)
+			case isBoolFlag(fs.Lookup(name)):
+				fs.Set(name, "true")
+			default:
+				pending = fs.Lookup(name)
+			}
+
+			continue
+		}
+
+		if len(args) == 0 {
+			if sub := cmd.find(word); sub != nil {
+				cmd = sub
+				fs = commandFlags(cmd, g, sub.Name)
+
+				continue
+			}
+		}
+
+		args = append(args, word)
+	}
+
+	candidates := []string{}
+
+	switch {
+	case pending != nil:
+		candidates = flagValues(g, pending.Name, current)
+	case strings.HasPrefix(current, "-"):
+		name, value, hasValue := strings.Cut(strings.TrimLeft(current, "-"), "=")
+		if hasValue {
+			for _, candidate := range flagValues(g, name, value) {
+				candidates = append(candidates, current[:len(current)-len(value)]+candidate)
+			}
+
+			break
+		}
+
+		fs.VisitAll(func(f *flag.Flag) {
+			if len(f.Name) == 1 {
+				candidates = append(candidates, "-"+f.Name)
+			} else {
+				candidates = append(candidates, "--"+f.Name)
+			}
+		})
+		candidates = append(candidates, "--help")
+	default:
+		if len(args) == 0 {
+			for _, sub := range cmd.Commands {
+				candidates = append(candidates, sub.Name)
+			}
+		}
+
+		if cmd.Complete != nil {
+			candidates = append(candidates, cmd.Complete(g, args)...)
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, current) {
+			fmt.Fprintln(out, candidate)
+		}
+	}
+}
+
+func commandFlags(cmd *Command, g *Globals, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	g.define(fs)
+
+	if cmd.Setup != nil {
+		cmd.Setup(fs, g)
+	}
+
+	return fs
+}
+
+// flagValues lists the values of the flag name, value is what was typed of it
+// so far.
+func flagValues(g *Globals, name string, value string) []string {
+	switch name {
+	case "format":
+		return []string{"table", "json", "plain"}
+	case "store":
+		return []string{"system", "file"}
+	case "columns":
+		// Only the last of the comma separated columns is completed.
+		typed := value[:strings.LastIndex(value, ",")+1]
+
+		values := []string{}
+		for _, column := range lsColumns {
+			if !slices.Contains(strings.Split(typed, ","), column) {
+				values = append(values, typed+column)
+			}
+		}
+
+		return values
+	case "proxy":
+		profiles, err := ListProfiles()
+		if err != nil {
+			return nil
+		}
+
+		names := []string{}
+		for _, p := range profiles {
+			if !slices.Contains(names, p.Name) {
+				names = append(names, p.Name)
+			}
+		}
+
+		return names
+	}
+
+	return nil
+}
+
+// completeHosts lists the cached hostnames and the aliases of the profile.
+func completeHosts(g *Globals, args []string) []string {
+	profile, err := g.Profile()
+	if err != nil {
+		return nil
+	}
+
+	hosts := cachedHostnames(profile)
+
+	favourites, err := LoadFavourites(profile)
+	if err == nil {
+		for _, favourite := range favourites.Entries {
+			if favourite.Alias != "" {
+				hosts = append(hosts, favourite.Alias)
+			}
+		}
+	}
+
+	return hosts
+}
+
+func completeHostnames(g *Globals) []string {
+	profile, err := g.Profile()
+	if err != nil {
+		return nil
+	}
+
+	return cachedHostnames(profile)
+}
+
+func cachedHostnames(p Profile) []string {
+	path, err := GetHostnamesPath(p)
+	if err != nil {
+		return nil
+	}
+
+	file, err := os.ReadFile(path)
+	if err == nil {
+		return strings.Fields(string(file))
+	}
+
+	// Caches stored before the hostnames file existed.
+	cachePath, err := GetCachePath(p)
+	if err != nil {
+		return nil
+	}
+
+	file, err = os.ReadFile(cachePath)
+	if err != nil {
+		return nil
+	}
+
+	info := ServersInfo{}
+	if json.Unmarshal(file, &info) != nil {
+		return nil
+	}
+
+	hostnames := []string{}
+	for _, server := range info.Servers {
+		hostnames = append(hostnames, server.Hostname)
+	}
+
+	return hostnames
+}
+
+// completeFirst completes only the first argument with complete.
+func completeFirst(complete func(g *Globals) []string) func(g *Globals, args []string) []string {
+	return func(g *Globals, args []string) []string {
+		if len(args) != 0 {
+			return nil
+		}
+
+		return complete(g)
+	}
+}
+
+func completeFavourites(g *Globals) []string {
+	profile, err := g.Profile()
+	if err != nil {
+		return nil
+	}
+
+	favourites, err := LoadFavourites(profile)
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+	for _, favourite := range favourites.Entries {
+		if favourite.Alias != "" {
+			names = append(names, favourite.Alias)
+		}
+
+		names = append(names, favourite.Hostname)
+	}
+
+	return names
+}
+
+func completeHistory(g *Globals) []string {
+	profile, err := g.Profile()
+	if err != nil {
+		return nil
+	}
+
+	path, err := GetHistoryPath(profile)
+	if err != nil {
+		return nil
+	}
+
+	// LoadHistory would create the history, completion only reads it.
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	history := History{}
+	if json.Unmarshal(file, &history) != nil {
+		return nil
+	}
+
+	hostnames := []string{}
+	for _, entry := range history.Sorted() {
+		if !slices.Contains(hostnames, entry.Hostname) {
+			hostnames = append(hostnames, entry.Hostname)
+		}
+	}
+
+	return hostnames
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// useCompletionCache logs alice in to teleport.example.com, the current tsh
+// profile, and caches hostnames, favourites and history for it.
+func useCompletionCache(t testing.TB, hostnames []string) {
+	t.Helper()
+
+	useTempHome(t, "teleport.example.com")
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(home, ".tsh", "teleport.example.com.yaml"), []byte("web_proxy_addr: teleport.example.com:443\nuser: alice\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := Profile{Name: "teleport.example.com", Proxy: "teleport.example.com:443", User: "alice"}
+
+	path, err := GetHostnamesPath(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, []byte(strings.Join(hostnames, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = StoreFavourites(p, &Favourites{Entries: []Favourite{{Hostname: "db-primary", Alias: "prod"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history := &History{Entries: []HistoryEntry{{Hostname: "web-2", Cluster: "root", Count: 1}}}
+
+	err = StoreHistory(p, history)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	useCompletionCache(t, []string{"db-primary", "web-1", "web-2"})
+
+	tests := []struct {
+		words []string
+		want  []string
+		// contains only checks that want is among the candidates.
+		contains bool
+	}{
+		{words: []string{""}, want: []string{"connect", "ls", "login", "db-primary", "web-1", "web-2", "prod"}, contains: true},
+		{words: []string{"we"}, want: []string{"web-1", "web-2"}},
+		{words: []string{"pr"}, want: []string{"prod"}},
+		{words: []string{"l"}, want: []string{"ls", "login", "logout"}},
+		{words: []string{"ls", "web-"}, want: []string{"web-1", "web-2"}},
+		{words: []string{"ls", "--fo"}, want: []string{"--format"}},
+		{words: []string{"ls", "--format", ""}, want: []string{"table", "json", "plain"}},
+		{words: []string{"ls", "--format=j"}, want: []string{"--format=json"}},
+		{words: []string{"ls", "--columns=hostname,"}, want: []string{"--columns=hostname,cluster", "--columns=hostname,labels", "--columns=hostname,last_used"}},
+		{words: []string{"--pro"}, want: []string{"--proxy"}},
+		{words: []string{"--proxy", ""}, want: []string{"teleport.example.com"}},
+		{words: []string{"cache", ""}, want: []string{"location", "prune"}},
+		{words: []string{"fav", "rm", ""}, want: []string{"prod", "db-primary"}},
+		{words: []string{"history", "forget", ""}, want: []string{"web-2"}},
+		{words: []string{"completion", "b"}, want: []string{"bash"}},
+		{words: []string{"web-1", "--", ""}, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		Complete(&buf, RootCommand(), tt.words)
+
+		got := strings.Fields(buf.String())
+		if got == nil {
+			got = []string{}
+		}
+
+		if tt.contains {
+			for _, want := range tt.want {
+				if !slices.Contains(got, want) {
+					t.Errorf("Complete(%q) = %q, want %q among them", tt.words, got, want)
+				}
+			}
+
+			continue
+		}
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Complete(%q) = %q, want %q", tt.words, got, tt.want)
+		}
+	}
+}
+
+// Completing must stay well under 50ms with 20k cached hosts.
+func BenchmarkCompleteHosts(b *testing.B) {
+	hostnames := make([]string, 20000)
+	for i := range hostnames {
+		hostnames[i] = fmt.Sprintf("node-%05d.eu-west-1.example.com", i)
+	}
+
+	useCompletionCache(b, hostnames)
+
+	root := RootCommand()
+	words := []string{"ls", "node-1"}
+
+	b.ResetTimer()
+
+	for range b.N {
+		Complete(io.Discard, root, words)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// defaultCacheTTL is how old the server cache may get before it is
+	// refreshed in the background.
+	defaultCacheTTL = time.Hour
+
+	defaultFetchTimeout = time.Minute
+)
+
+type Config struct {
+	// CacheTTL and FetchTimeout are durations such as "30m". A CacheTTL of
+	// "0" refreshes on every start.
+	CacheTTL     string                   `json:"cache_ttl"`
+	FetchTimeout string                   `json:"fetch_timeout"`
+	Profiles     map[string]ProfileConfig `json:"profiles"`
+}
+
+// ProfileConfig holds settings for a single tsh profile, keyed by the profile
+// name (the proxy host) in Config.Profiles.
+type ProfileConfig struct {
+	Auth    *AuthProvider `json:"auth"`
+	Filter  NodeFilter    `json:"filter"`
+	Logins  []LoginRule   `json:"logins"`
+	SSHArgs []SSHArgsRule `json:"ssh_args"`
+}
+
+// HostMatch selects the servers whose hostname matches Host and that have
+// all of Labels. Host and the label values are globs, or regular expressions
+// when wrapped in ^ and $, an empty Host matches any server.
+type HostMatch struct {
+	Host   string            `json:"host"`
+	Labels map[string]string `json:"labels"`
+}
+
+// LoginRule sets the login for the servers it matches.
+type LoginRule struct {
+	HostMatch
+	Login string `json:"login"`
+}
+
+// SSHArgsRule sets the tsh ssh arguments for the servers it matches, the
+// ones given when connecting are added after them.
+type SSHArgsRule struct {
+	HostMatch
+	Args []string `json:"args"`
+}
+
+func GetConfigDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "tssh"), nil
+}
+
+func GetConfigPath() (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "config.json"), nil
+}
+
+func LoadConfig() (*Config, error) {
+	filepath, err := GetConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.ReadFile(filepath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	config := Config{}
+	err = json.Unmarshal(file, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func (c *Config) Profile(name string) ProfileConfig {
+	return c.Profiles[name]
+}
+
+func LoadLoginRules(p Profile) ([]LoginRule, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return config.Profile(p.Name).Logins, nil
+}
+
+func LoadSSHArgsRules(p Profile) ([]SSHArgsRule, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return config.Profile(p.Name).SSHArgs, nil
+}
+
+func (r HostMatch) Matches(hostname string, labels map[string]string) bool {
+	if r.Host != "" && !matchLabelValue(r.Host, hostname) {
+		return false
+	}
+
+	for key, pattern := range r.Labels {
+		value, ok := labels[key]
+		if !ok || !matchLabelValue(pattern, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getCacheTTL() (time.Duration, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return 0, err
+	}
+
+	return parseConfigDuration("cache_ttl", config.CacheTTL, defaultCacheTTL)
+}
+
+func getFetchTimeout() (time.Duration, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return 0, err
+	}
+
+	return parseConfigDuration("fetch_timeout", config.FetchTimeout, defaultFetchTimeout)
+}
+
+func parseConfigDuration(name string, value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return duration, nil
+}
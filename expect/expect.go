@@ -0,0 +1,167 @@
+package expect
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTimeout = errors.New("timed out waiting for a known prompt")
+	ErrClosed  = errors.New("output closed")
+)
+
+// Prompt is a named pattern Session waits for in the process output.
+type Prompt struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+func NewPrompt(name string, pattern string) Prompt {
+	return Prompt{
+		Name:    name,
+		Pattern: regexp.MustCompile(pattern),
+	}
+}
+
+// Error is returned by Expect when none of the prompts matched. Output holds
+// what the process printed since the last match.
+type Error struct {
+	Err    error
+	Output string
+}
+
+func (e *Error) Error() string {
+	line := lastLine(e.Output)
+
+	if errors.Is(e.Err, ErrTimeout) && line != "" {
+		return fmt.Sprintf("unexpected prompt %q", line)
+	}
+
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Session drives an interactive process, usually through a pty, by waiting
+// for prompts and answering them. Everything read and sent is kept in a
+// transcript, secrets are recorded by name only.
+type Session struct {
+	w io.Writer
+
+	mu         sync.Mutex
+	pending    []byte
+	transcript bytes.Buffer
+	err        error
+	updated    chan struct{}
+}
+
+func New(rw io.ReadWriter) *Session {
+	s := &Session{
+		w:       rw,
+		updated: make(chan struct{}),
+	}
+
+	go s.read(rw)
+
+	return s
+}
+
+func (s *Session) read(r io.Reader) {
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := r.Read(buf)
+
+		s.mu.Lock()
+
+		s.pending = append(s.pending, buf[:n]...)
+		s.transcript.Write(buf[:n])
+
+		if err != nil {
+			s.err = err
+		}
+
+		close(s.updated)
+		s.updated = make(chan struct{})
+
+		s.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Expect waits up to timeout for any of prompts to appear in the output and
+// consumes the output up to the end of the match. When several prompts match,
+// the one listed first wins.
+func (s *Session) Expect(timeout time.Duration, prompts ...Prompt) (Prompt, string, error) {
+	deadline := time.After(timeout)
+
+	for {
+		s.mu.Lock()
+
+		for _, prompt := range prompts {
+			loc := prompt.Pattern.FindIndex(s.pending)
+			if loc == nil {
+				continue
+			}
+
+			match := string(s.pending[loc[0]:loc[1]])
+			s.pending = s.pending[loc[1]:]
+
+			s.mu.Unlock()
+
+			return prompt, match, nil
+		}
+
+		pending := string(s.pending)
+		err := s.err
+		updated := s.updated
+
+		s.mu.Unlock()
+
+		if err != nil {
+			return Prompt{}, "", &Error{Err: ErrClosed, Output: pending}
+		}
+
+		select {
+		case <-updated:
+		case <-deadline:
+			return Prompt{}, "", &Error{Err: ErrTimeout, Output: pending}
+		}
+	}
+}
+
+// Send writes text followed by a newline. name is what the transcript shows
+// in place of text.
+func (s *Session) Send(name string, text string) error {
+	s.mu.Lock()
+	fmt.Fprintf(&s.transcript, "\n[sent %s]\n", name)
+	s.mu.Unlock()
+
+	_, err := io.WriteString(s.w, text+"\n")
+
+	return err
+}
+
+func (s *Session) Transcript() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transcript.String()
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+
+	return strings.TrimSpace(lines[len(lines)-1])
+}
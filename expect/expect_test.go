@@ -0,0 +1,174 @@
+package expect
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	passwordPrompt = NewPrompt("password", `(?i)password:\s*$`)
+	otpPrompt      = NewPrompt("otp", `(?i)otp code:\s*$`)
+	errorPrompt    = NewPrompt("error", `ERROR:[^\n]*\n`)
+)
+
+// process is the other end of a Session: what is written to output is read
+// by the session, what the session sends arrives in input.
+type process struct {
+	io.Reader
+	io.Writer
+
+	output *io.PipeWriter
+	input  *io.PipeReader
+}
+
+func newProcess() *process {
+	outputReader, outputWriter := io.Pipe()
+	inputReader, inputWriter := io.Pipe()
+
+	return &process{
+		Reader: outputReader,
+		Writer: inputWriter,
+		output: outputWriter,
+		input:  inputReader,
+	}
+}
+
+func TestExpect(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+		match  string
+	}{
+		{
+			name:   "password prompt",
+			output: "Enter password:",
+			want:   "password",
+			match:  "password:",
+		},
+		{
+			name:   "prompt with trailing space",
+			output: "Enter your OTP code: ",
+			want:   "otp",
+			match:  "OTP code: ",
+		},
+		{
+			name:   "first listed prompt wins",
+			output: "ERROR: access denied\nEnter password:",
+			want:   "error",
+			match:  "ERROR: access denied\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProcess()
+			session := New(p)
+
+			go io.WriteString(p.output, tt.output)
+
+			prompt, match, err := session.Expect(time.Second, errorPrompt, passwordPrompt, otpPrompt)
+			if err != nil {
+				t.Fatalf("Expect() error = %v", err)
+			}
+
+			if prompt.Name != tt.want || match != tt.match {
+				t.Errorf("Expect() = %q, %q, want %q, %q", prompt.Name, match, tt.want, tt.match)
+			}
+		})
+	}
+}
+
+func TestExpectConsumesMatch(t *testing.T) {
+	p := newProcess()
+	session := New(p)
+
+	go io.WriteString(p.output, "Enter password:")
+
+	_, _, err := session.Expect(time.Second, passwordPrompt)
+	if err != nil {
+		t.Fatalf("Expect() error = %v", err)
+	}
+
+	_, _, err = session.Expect(50*time.Millisecond, passwordPrompt)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("second Expect() error = %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestExpectUnexpectedPrompt(t *testing.T) {
+	p := newProcess()
+	session := New(p)
+
+	go io.WriteString(p.output, "Welcome\nEnter your PIN: ")
+
+	_, _, err := session.Expect(50*time.Millisecond, passwordPrompt, otpPrompt)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expect() error = %v, want %v", err, ErrTimeout)
+	}
+
+	want := `unexpected prompt "Enter your PIN:"`
+	if err.Error() != want {
+		t.Errorf("Expect() error = %q, want %q", err, want)
+	}
+
+	var expectErr *Error
+	if !errors.As(err, &expectErr) || expectErr.Output != "Welcome\nEnter your PIN: " {
+		t.Errorf("Expect() error output = %#v", err)
+	}
+}
+
+func TestExpectClosedOutput(t *testing.T) {
+	p := newProcess()
+	session := New(p)
+
+	go func() {
+		io.WriteString(p.output, "Goodbye\n")
+		p.output.Close()
+	}()
+
+	_, _, err := session.Expect(time.Second, passwordPrompt)
+	if !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expect() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestTranscriptRedactsSecrets(t *testing.T) {
+	p := newProcess()
+	session := New(p)
+
+	go io.WriteString(p.output, "Enter password:")
+
+	_, _, err := session.Expect(time.Second, passwordPrompt)
+	if err != nil {
+		t.Fatalf("Expect() error = %v", err)
+	}
+
+	sent := make(chan string)
+	go func() {
+		buf := make([]byte, 64)
+		n, _ := p.input.Read(buf)
+		sent <- string(buf[:n])
+	}()
+
+	err = session.Send("password", "hunter2")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	if got := <-sent; got != "hunter2\n" {
+		t.Errorf("process got %q, want %q", got, "hunter2\n")
+	}
+
+	transcript := session.Transcript()
+	if strings.Contains(transcript, "hunter2") {
+		t.Errorf("Transcript() = %q, contains the secret", transcript)
+	}
+
+	if !strings.Contains(transcript, "Enter password:\n[sent password]\n") {
+		t.Errorf("Transcript() = %q, want the prompt followed by the name of what was sent", transcript)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Favourite is a pinned server, optionally with a short alias that finds it
+// exactly in the search box and with 'tssh <alias>'.
+type Favourite struct {
+	Hostname string `json:"hostname"`
+	Cluster  string `json:"cluster"`
+	Alias    string `json:"alias,omitempty"`
+}
+
+// Favourites are kept per profile in the config dir, so neither refreshing
+// nor pruning the cache loses them.
+type Favourites struct {
+	Entries []Favourite `json:"entries"`
+}
+
+func GetFavouritesPath(p Profile) (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "profiles", p.Key(), "favourites.json"), nil
+}
+
+func LoadFavourites(p Profile) (*Favourites, error) {
+	path, err := GetFavouritesPath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Favourites{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	favourites := Favourites{}
+	err = json.Unmarshal(file, &favourites)
+	if err != nil {
+		return nil, err
+	}
+
+	return &favourites, nil
+}
+
+func StoreFavourites(p Profile, favourites *Favourites) error {
+	data, err := json.MarshalIndent(favourites, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path, err := GetFavouritesPath(p)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// Find returns the favourite of server.
+func (f *Favourites) Find(server Server) (Favourite, bool) {
+	i := slices.IndexFunc(f.Entries, func(favourite Favourite) bool {
+		return favourite.Hostname == server.Hostname && favourite.Cluster == server.Cluster
+	})
+	if i < 0 {
+		return Favourite{}, false
+	}
+
+	return f.Entries[i], true
+}
+
+// FindAlias returns the favourite with the alias.
+func (f *Favourites) FindAlias(alias string) (Favourite, bool) {
+	i := slices.IndexFunc(f.Entries, func(favourite Favourite) bool {
+		return favourite.Alias != "" && favourite.Alias == alias
+	})
+	if i < 0 {
+		return Favourite{}, false
+	}
+
+	return f.Entries[i], true
+}
+
+// Add pins server, or changes the alias of a pinned one.
+func (f *Favourites) Add(server Server, alias string) error {
+	if other, ok := f.FindAlias(alias); ok && (other.Hostname != server.Hostname || other.Cluster != server.Cluster) {
+		return fmt.Errorf("alias %s is already used for %s", alias, other.Hostname)
+	}
+
+	favourite := Favourite{Hostname: server.Hostname, Cluster: server.Cluster, Alias: alias}
+
+	i := slices.IndexFunc(f.Entries, func(other Favourite) bool {
+		return other.Hostname == server.Hostname && other.Cluster == server.Cluster
+	})
+	if i < 0 {
+		f.Entries = append(f.Entries, favourite)
+	} else {
+		f.Entries[i] = favourite
+	}
+
+	return nil
+}
+
+// Remove unpins the server with the hostname or alias name.
+func (f *Favourites) Remove(name string) bool {
+	before := len(f.Entries)
+
+	f.Entries = slices.DeleteFunc(f.Entries, func(favourite Favourite) bool {
+		return favourite.Alias == name || favourite.Hostname == name
+	})
+
+	return len(f.Entries) != before
+}
+
+// ResolveServer finds the cached server named by "host" or "cluster/host".
+// A host in several clusters needs the cluster.
+func ResolveServer(info *ServersInfo, name string) (Server, error) {
+	cluster, hostname, hasCluster := strings.Cut(name, "/")
+	if !hasCluster {
+		hostname = name
+	}
+
+	found := []Server{}
+	for _, server := range info.Servers {
+		if server.Hostname == hostname && (!hasCluster || server.Cluster == cluster) {
+			found = append(found, server)
+		}
+	}
+
+	switch {
+	case len(found) == 0:
+		return Server{}, fmt.Errorf("%s is not in the servers cache, run 'tssh' and press ctrl+r to update it", name)
+	case len(found) > 1 && !hasCluster && found[0].Cluster != found[1].Cluster:
+		return Server{}, fmt.Errorf("%s is in several clusters, use <cluster>/%s", hostname, hostname)
+	}
+
+	return found[0], nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFavouritesAdd(t *testing.T) {
+	favourites := &Favourites{}
+
+	web := Server{Hostname: "web-1", Cluster: "root"}
+	leafWeb := Server{Hostname: "web-1", Cluster: "leaf"}
+
+	err := favourites.Add(web, "w")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = favourites.Add(leafWeb, "w")
+	if err == nil || !strings.Contains(err.Error(), "alias w is already used for web-1") {
+		t.Errorf("Add() with a used alias error = %v", err)
+	}
+
+	// Adding again changes the alias of the same favourite.
+	err = favourites.Add(web, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = favourites.Add(leafWeb, "w")
+	if err != nil {
+		t.Errorf("Add() with a freed alias error = %v", err)
+	}
+
+	if len(favourites.Entries) != 2 {
+		t.Fatalf("Entries = %+v", favourites.Entries)
+	}
+
+	if favourite, ok := favourites.FindAlias("web"); !ok || favourite.Cluster != "root" {
+		t.Errorf("FindAlias(web) = %+v, %v", favourite, ok)
+	}
+
+	if !favourites.Remove("w") || len(favourites.Entries) != 1 {
+		t.Errorf("Remove(w) left %+v", favourites.Entries)
+	}
+
+	if favourites.Remove("w") {
+		t.Error("Remove(w) removed twice")
+	}
+}
+
+func TestResolveServer(t *testing.T) {
+	info := &ServersInfo{Servers: []Server{
+		{UUID: "1", Hostname: "web-1", Cluster: "root"},
+		{UUID: "2", Hostname: "api", Cluster: "root"},
+		{UUID: "3", Hostname: "api", Cluster: "leaf"},
+		{UUID: "4", Hostname: "db", Cluster: "root"},
+		{UUID: "5", Hostname: "db", Cluster: "root"},
+	}}
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{name: "web-1", want: "1"},
+		{name: "root/web-1", want: "1"},
+		{name: "leaf/api", want: "3"},
+		{name: "api", wantErr: "api is in several clusters, use <cluster>/api"},
+		{name: "db", want: "4"},
+		{name: "leaf/web-1", wantErr: "leaf/web-1 is not in the servers cache"},
+		{name: "web-2", wantErr: "web-2 is not in the servers cache"},
+	}
+
+	for _, tt := range tests {
+		server, err := ResolveServer(info, tt.name)
+
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ResolveServer(%q) error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil || server.UUID != tt.want {
+			t.Errorf("ResolveServer(%q) = %+v, %v, want UUID %s", tt.name, server, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	fileStoreKeyPassphrase = "passphrase"
+	fileStoreKeyFile       = "keyfile"
+)
+
+// FileStore keeps Auth encrypted with AES-GCM in the user config dir. The key
+// is derived with scrypt either from TSSH_PASSPHRASE or from a random local
+// key file that is created on the first Store.
+type FileStore struct {
+	path       string
+	keyPath    string
+	passphrase string
+}
+
+type encryptedAuth struct {
+	Key   string `json:"key"`
+	Salt  []byte `json:"salt"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// NewFileStore returns the encrypted file for account, "" is the file used
+// before auth was stored per profile.
+func NewFileStore(account string) (FileStore, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return FileStore{}, err
+	}
+
+	name := "auth"
+	if account != "" {
+		name = "auth-" + account
+	}
+
+	return FileStore{
+		path:       filepath.Join(configDir, name+".enc"),
+		keyPath:    filepath.Join(configDir, name+".key"),
+		passphrase: os.Getenv("TSSH_PASSPHRASE"),
+	}, nil
+}
+
+func (s FileStore) Exists() bool {
+	_, err := os.Stat(s.path)
+
+	return err == nil
+}
+
+func (s FileStore) Name() string {
+	return "encrypted file " + s.path
+}
+
+func (s FileStore) Store(auth Auth) error {
+	err := os.MkdirAll(filepath.Dir(s.path), os.ModeDir|0700)
+	if err != nil {
+		return err
+	}
+
+	if s.passphrase != "" {
+		return s.write(auth, fileStoreKeyPassphrase, []byte(s.passphrase))
+	}
+
+	material, err := s.readOrCreateKeyFile()
+	if err != nil {
+		return err
+	}
+
+	return s.write(auth, fileStoreKeyFile, material)
+}
+
+// write encrypts auth with the key derived from material and replaces the
+// stored file.
+func (s FileStore) write(auth Auth, keySource string, material []byte) error {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := fileStoreCipher(material, salt)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+
+	file, err := json.Marshal(encryptedAuth{
+		Key:   keySource,
+		Salt:  salt,
+		Nonce: nonce,
+		Data:  gcm.Seal(nil, nonce, data, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	return writePrivateFile(s.path, file)
+}
+
+func (s FileStore) Get() (*Auth, error) {
+	file, err := readPrivateFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := encryptedAuth{}
+	err = json.Unmarshal(file, &encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	var material []byte
+
+	switch encrypted.Key {
+	case fileStoreKeyPassphrase:
+		if s.passphrase == "" {
+			return nil, fmt.Errorf("%s is protected by a passphrase, set TSSH_PASSPHRASE", s.path)
+		}
+
+		material = []byte(s.passphrase)
+	case fileStoreKeyFile:
+		material, err = readPrivateFile(s.keyPath)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown key source %q", s.path, encrypted.Key)
+	}
+
+	gcm, err := fileStoreCipher(material, encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := gcm.Open(nil, encrypted.Nonce, encrypted.Data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt %s: wrong passphrase or key file", s.path)
+	}
+
+	auth := &Auth{}
+
+	err = json.Unmarshal(data, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+func (s FileStore) Delete() error {
+	err := os.Remove(s.path)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(s.keyPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Move renames the encrypted file and its key file to those of other.
+func (s FileStore) Move(other FileStore) error {
+	err := os.Rename(s.keyPath, other.keyPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	err = os.Rename(s.path, other.path)
+	if err != nil {
+		os.Rename(other.keyPath, s.keyPath)
+
+		return err
+	}
+
+	return nil
+}
+
+// Rekey re-encrypts the stored Auth with passphrase, or with a freshly
+// generated key file when passphrase is empty. The old key file is replaced
+// only once the file encrypted with the new key is in place.
+func (s FileStore) Rekey(passphrase string) error {
+	auth, err := s.Get()
+	if err != nil {
+		return err
+	}
+
+	if auth == nil {
+		return fmt.Errorf("%s not found, run 'tssh login --store=file' first", s.path)
+	}
+
+	if passphrase != "" {
+		s.passphrase = passphrase
+
+		err = s.Store(*auth)
+		if err != nil {
+			return err
+		}
+
+		err = os.Remove(s.keyPath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	}
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return err
+	}
+
+	newKeyPath := s.keyPath + ".new"
+
+	err = writePrivateFile(newKeyPath, key)
+	if err != nil {
+		return err
+	}
+
+	err = s.write(*auth, fileStoreKeyFile, key)
+	if err != nil {
+		os.Remove(newKeyPath)
+
+		return err
+	}
+
+	err = os.Rename(newKeyPath, s.keyPath)
+	if err != nil {
+		return fmt.Errorf("%s is encrypted with the key in %s, but it can't replace %s: %w", s.path, newKeyPath, s.keyPath, err)
+	}
+
+	return nil
+}
+
+func (s FileStore) readOrCreateKeyFile() ([]byte, error) {
+	key, err := readPrivateFile(s.keyPath)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return key, err
+	}
+
+	key = make([]byte, 32)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, writePrivateFile(s.keyPath, key)
+}
+
+func fileStoreCipher(material []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(material, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// readPrivateFile refuses to read secrets that other users can access.
+func readPrivateFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users (%#o), run 'chmod 600 %s'", path, info.Mode().Perm(), path)
+	}
+
+	return os.ReadFile(path)
+}
+
+// writePrivateFile replaces path with data only the user can access. The data
+// is written to a temporary file renamed over path, so a failed write leaves
+// the old file intact.
+func writePrivateFile(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
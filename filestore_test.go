@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testAuth = Auth{Password: "hunter2", Secret: "JBSWY3DPEHPK3PXP", Digits: 8}
+
+func newTestFileStore(t *testing.T) FileStore {
+	t.Helper()
+
+	useTempHome(t, "")
+
+	store, err := NewFileStore("alice@teleport.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+// checkPrivate fails unless path exists and only the user can access it.
+func checkPrivate(t *testing.T, path string) {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("%s has mode %#o, want 0600", path, info.Mode().Perm())
+	}
+}
+
+func checkAuth(t *testing.T, store FileStore) {
+	t.Helper()
+
+	auth, err := store.Get()
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if auth == nil || auth.Password != testAuth.Password || auth.Secret != testAuth.Secret || auth.Digits != testAuth.Digits {
+		t.Fatalf("Get() = %+v, want %+v", auth, testAuth)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		keyFile    bool
+	}{
+		{name: "key file", keyFile: true},
+		{name: "passphrase", passphrase: "correct horse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TSSH_PASSPHRASE", tt.passphrase)
+			store := newTestFileStore(t)
+
+			auth, err := store.Get()
+			if err != nil || auth != nil {
+				t.Fatalf("Get() before Store() = %+v, %v", auth, err)
+			}
+
+			err = store.Store(testAuth)
+			if err != nil {
+				t.Fatalf("Store() error = %v", err)
+			}
+
+			checkPrivate(t, store.path)
+			checkAuth(t, store)
+
+			data, err := os.ReadFile(store.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(string(data), testAuth.Password) {
+				t.Errorf("%s contains the password", store.path)
+			}
+
+			_, err = os.Stat(store.keyPath)
+			if tt.keyFile {
+				checkPrivate(t, store.keyPath)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s exists with a passphrase: %v", store.keyPath, err)
+			}
+
+			err = store.Delete()
+			if err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+
+			entries, err := os.ReadDir(filepath.Dir(store.path))
+			if err != nil || len(entries) != 0 {
+				t.Errorf("left after Delete(): %v, %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestFileStoreWrongPassphrase(t *testing.T) {
+	t.Setenv("TSSH_PASSPHRASE", "correct horse")
+	store := newTestFileStore(t)
+
+	err := store.Store(testAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.passphrase = "battery staple"
+
+	_, err = store.Get()
+	if err == nil || !strings.Contains(err.Error(), "can't decrypt") {
+		t.Errorf("Get() error = %v, want a decrypt error", err)
+	}
+
+	store.passphrase = ""
+
+	_, err = store.Get()
+	if err == nil || !strings.Contains(err.Error(), "set TSSH_PASSPHRASE") {
+		t.Errorf("Get() without passphrase error = %v", err)
+	}
+}
+
+func TestFileStoreRefusesReadableFiles(t *testing.T) {
+	t.Setenv("TSSH_PASSPHRASE", "")
+	store := newTestFileStore(t)
+
+	err := store.Store(testAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{store.path, store.keyPath} {
+		err = os.Chmod(path, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = store.Get()
+		if err == nil || !strings.Contains(err.Error(), "accessible by other users") {
+			t.Errorf("Get() with %s readable error = %v", filepath.Base(path), err)
+		}
+
+		err = os.Chmod(path, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkAuth(t, store)
+}
+
+func TestFileStoreRekey(t *testing.T) {
+	t.Setenv("TSSH_PASSPHRASE", "")
+	store := newTestFileStore(t)
+
+	err := store.Store(testAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey, err := os.ReadFile(store.keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Key file to passphrase: the key file is not needed anymore.
+	err = store.Rekey("correct horse")
+	if err != nil {
+		t.Fatalf("Rekey() to a passphrase error = %v", err)
+	}
+
+	_, err = os.Stat(store.keyPath)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("key file after Rekey() to a passphrase: %v", err)
+	}
+
+	checkPrivate(t, store.path)
+
+	_, err = store.Get()
+	if err == nil {
+		t.Error("Get() without the passphrase succeeded")
+	}
+
+	store.passphrase = "correct horse"
+	checkAuth(t, store)
+
+	// Passphrase to a new key file.
+	err = store.Rekey("")
+	if err != nil {
+		t.Fatalf("Rekey() to a key file error = %v", err)
+	}
+
+	store.passphrase = ""
+	checkPrivate(t, store.path)
+	checkPrivate(t, store.keyPath)
+	checkAuth(t, store)
+
+	newKey, err := os.ReadFile(store.keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(newKey) == string(oldKey) {
+		t.Error("Rekey() kept the old key")
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(store.path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if entry.Name() != filepath.Base(store.path) && entry.Name() != filepath.Base(store.keyPath) {
+			t.Errorf("left after Rekey(): %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// maxFrecencyCount caps how much connecting over and over adds to the
+// frecency of a server.
+const maxFrecencyCount = 10
+
+// HistoryEntry records the connections made to one server.
+type HistoryEntry struct {
+	Hostname string    `json:"hostname"`
+	Cluster  string    `json:"cluster"`
+	User     string    `json:"user"`
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"last_used"`
+
+	// Args are the tsh ssh arguments of the last connection.
+	Args []string `json:"args,omitempty"`
+}
+
+// History is the connection history of a profile. It is kept in the config
+// dir next to the favourites, so neither refreshing nor pruning the cache
+// touches it.
+type History struct {
+	Entries []HistoryEntry `json:"entries"`
+}
+
+func GetHistoryPath(p Profile) (string, error) {
+	favouritesPath, err := GetFavouritesPath(p)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(favouritesPath), "history.json"), nil
+}
+
+// LoadHistory returns the history of p. A profile without one yet gets the
+// recently used servers of info, its servers cache, which may be nil.
+func LoadHistory(p Profile, info *ServersInfo) (*History, error) {
+	path, err := GetHistoryPath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && moveCachedHistory(p, path) == nil {
+		file, err = os.ReadFile(path)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		history := legacyHistory(info)
+
+		return history, StoreHistory(p, history)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	history := History{}
+	err = json.Unmarshal(file, &history)
+	if err != nil {
+		return nil, err
+	}
+
+	return &history, nil
+}
+
+func StoreHistory(p Profile, history *History) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	path, err := GetHistoryPath(p)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// moveCachedHistory moves the history tssh used to keep in the cache dir to
+// path.
+func moveCachedHistory(p Profile, path string) error {
+	cachePath, err := GetCachePath(p)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(filepath.Dir(cachePath), "history.json"), path)
+}
+
+func legacyHistory(info *ServersInfo) *History {
+	history := &History{}
+
+	if info == nil {
+		return history
+	}
+
+	// Only the order is known, the most recent one comes first.
+	now := time.Now()
+	for i, hostname := range info.RecentlyUsedServers {
+		if hostname == "" {
+			continue
+		}
+
+		history.Entries = append(history.Entries, HistoryEntry{
+			Hostname: hostname,
+			Cluster:  serverCluster(info, hostname),
+			User:     info.DefaultLogin,
+			Count:    1,
+			LastUsed: now.Add(-time.Duration(i) * time.Minute),
+		})
+	}
+
+	return history
+}
+
+func serverCluster(info *ServersInfo, hostname string) string {
+	for _, server := range info.Servers {
+		if server.Hostname == hostname {
+			return server.Cluster
+		}
+	}
+
+	return ""
+}
+
+func (h *History) entry(server Server) int {
+	return slices.IndexFunc(h.Entries, func(entry HistoryEntry) bool {
+		return entry.Hostname == server.Hostname && entry.Cluster == server.Cluster
+	})
+}
+
+// Record adds a connection to server as user with the tsh ssh arguments
+// args.
+func (h *History) Record(server Server, user string, args []string, t time.Time) {
+	i := h.entry(server)
+	if i < 0 {
+		h.Entries = append(h.Entries, HistoryEntry{Hostname: server.Hostname, Cluster: server.Cluster})
+		i = len(h.Entries) - 1
+	}
+
+	h.Entries[i].User = user
+	h.Entries[i].Args = args
+	h.Entries[i].Count++
+	h.Entries[i].LastUsed = t
+}
+
+// Last returns the last connection to server.
+func (h *History) Last(server Server) (HistoryEntry, bool) {
+	i := h.entry(server)
+	if i < 0 {
+		return HistoryEntry{}, false
+	}
+
+	return h.Entries[i], true
+}
+
+// Forget removes the entries of hostname, in every cluster, and returns how
+// many there were.
+func (h *History) Forget(hostname string) int {
+	before := len(h.Entries)
+
+	h.Entries = slices.DeleteFunc(h.Entries, func(entry HistoryEntry) bool {
+		return entry.Hostname == hostname
+	})
+
+	return before - len(h.Entries)
+}
+
+// Frecency scores server from 0 to 100 by how often and how recently it was
+// connected to.
+func (h *History) Frecency(server Server, now time.Time) int {
+	i := h.entry(server)
+	if i < 0 {
+		return 0
+	}
+
+	entry := h.Entries[i]
+	age := now.Sub(entry.LastUsed)
+
+	var recency int
+	switch {
+	case age < 4*time.Hour:
+		recency = 100
+	case age < 24*time.Hour:
+		recency = 80
+	case age < 7*24*time.Hour:
+		recency = 60
+	case age < 30*24*time.Hour:
+		recency = 40
+	case age < 90*24*time.Hour:
+		recency = 20
+	default:
+		recency = 10
+	}
+
+	return recency * min(entry.Count, maxFrecencyCount) / maxFrecencyCount
+}
+
+// Sorted returns the entries, the most recently used first.
+func (h *History) Sorted() []HistoryEntry {
+	entries := slices.Clone(h.Entries)
+	slices.SortFunc(entries, func(a HistoryEntry, b HistoryEntry) int {
+		return b.LastUsed.Compare(a.LastUsed)
+	})
+
+	return entries
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// useTempHome keeps the tsh profiles, the cache and the config of tssh in
+// temporary dirs, with current as the current tsh profile. The Secret Service
+// is made unreachable, so tests never touch the real one.
+func useTempHome(t testing.TB, current string) {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+filepath.Join(home, "missing"))
+
+	if current == "" {
+		return
+	}
+
+	err := os.MkdirAll(filepath.Join(home, ".tsh"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(home, ".tsh", "current-profile"), []byte(current), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeLegacyCache writes servers.json the way tssh did before profiles and
+// the history.
+func writeLegacyCache(t *testing.T) {
+	t.Helper()
+
+	cacheDir, err := GetCacheDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := `{
+		"default_login": "root",
+		"logins": ["root"],
+		"servers": ["web-1", "db-1"],
+		"recently_used_servers": ["db-1", "web-1", "", "", "", "", "", "", "", ""]
+	}`
+
+	err = os.WriteFile(filepath.Join(cacheDir, "servers.json"), []byte(legacy), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadHistoryFromLegacyCache(t *testing.T) {
+	useTempHome(t, "teleport.example.com")
+	writeLegacyCache(t)
+
+	p := Profile{Name: "teleport.example.com", User: "alice"}
+
+	info, err := GetServersInfoFromCache(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Like a fetch replacing the old cache before the history is loaded.
+	err = StoreServersInfo(p, &ServersInfo{Servers: info.Servers})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := LoadHistory(p, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sorted := history.Sorted()
+	if len(sorted) != 2 || sorted[0].Hostname != "db-1" || sorted[1].Hostname != "web-1" || sorted[0].User != "root" {
+		t.Fatalf("LoadHistory() = %+v, want db-1 then web-1 as root", sorted)
+	}
+
+	// The history is stored, the recents are not needed anymore.
+	stored, err := LoadHistory(p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stored.Entries) != 2 {
+		t.Errorf("stored history = %+v", stored.Entries)
+	}
+}
+
+func TestLegacyCacheOfCurrentProfileOnly(t *testing.T) {
+	useTempHome(t, "teleport.example.com")
+	writeLegacyCache(t)
+
+	_, err := GetServersInfoFromCache(Profile{Name: "other.example.com"})
+	if err == nil {
+		t.Fatal("other profile got the legacy cache")
+	}
+
+	// The old cache is moved, not read and deleted, so it survives until it
+	// is stored again.
+	p := Profile{Name: "teleport.example.com"}
+
+	for range 2 {
+		info, err := GetServersInfoFromCache(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(info.RecentlyUsedServers) == 0 {
+			t.Fatalf("GetServersInfoFromCache() lost the recents: %+v", info)
+		}
+	}
+}
+
+func TestHistoryRecord(t *testing.T) {
+	now := time.Now()
+	web := Server{Hostname: "web-1", Cluster: "root"}
+	leafWeb := Server{Hostname: "web-1", Cluster: "leaf"}
+
+	history := &History{}
+	history.Record(web, "root", nil, now.Add(-time.Hour))
+	history.Record(leafWeb, "ubuntu", nil, now.Add(-time.Minute))
+	history.Record(web, "deploy", []string{"-A"}, now)
+
+	// A reconnect updates the entry of the server instead of adding one.
+	if len(history.Entries) != 2 {
+		t.Fatalf("Entries = %+v, want one per server", history.Entries)
+	}
+
+	entry, ok := history.Last(web)
+	if !ok || entry.Count != 2 || entry.User != "deploy" || !slices.Equal(entry.Args, []string{"-A"}) || !entry.LastUsed.Equal(now) {
+		t.Errorf("Last() = %+v, %v", entry, ok)
+	}
+
+	sorted := history.Sorted()
+	if sorted[0].Cluster != "root" || sorted[1].Cluster != "leaf" {
+		t.Errorf("Sorted() = %+v, want the last connection first", sorted)
+	}
+
+	if _, ok := history.Last(Server{Hostname: "web-2"}); ok {
+		t.Error("Last() found a server never connected to")
+	}
+}
+
+func TestHistoryFrecency(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		age   time.Duration
+		count int
+		want  int
+	}{
+		{"just now, once", time.Minute, 1, 10},
+		{"just now, often", time.Minute, maxFrecencyCount, 100},
+		{"count is capped", time.Minute, 5 * maxFrecencyCount, 100},
+		{"today", 12 * time.Hour, maxFrecencyCount, 80},
+		{"this week", 3 * 24 * time.Hour, maxFrecencyCount, 60},
+		{"this month", 14 * 24 * time.Hour, maxFrecencyCount, 40},
+		{"this quarter", 60 * 24 * time.Hour, maxFrecencyCount, 20},
+		{"long ago", 365 * 24 * time.Hour, maxFrecencyCount, 10},
+		{"long ago, half the cap", 365 * 24 * time.Hour, maxFrecencyCount / 2, 5},
+	}
+
+	server := Server{Hostname: "web-1"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := &History{}
+			for range tt.count {
+				history.Record(server, "root", nil, now.Add(-tt.age))
+			}
+
+			if got := history.Frecency(server, now); got != tt.want {
+				t.Errorf("Frecency() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if got := (&History{}).Frecency(server, now); got != 0 {
+		t.Errorf("Frecency() of a server never connected to = %d", got)
+	}
+}
+
+func TestHistoryForget(t *testing.T) {
+	now := time.Now()
+
+	history := &History{}
+	history.Record(Server{Hostname: "web-1", Cluster: "root"}, "root", nil, now)
+	history.Record(Server{Hostname: "web-1", Cluster: "leaf"}, "root", nil, now)
+	history.Record(Server{Hostname: "db-1", Cluster: "root"}, "root", nil, now)
+
+	if n := history.Forget("web-1"); n != 2 {
+		t.Errorf("Forget() = %d, want 2", n)
+	}
+
+	if len(history.Entries) != 1 || history.Entries[0].Hostname != "db-1" {
+		t.Errorf("Entries = %+v, want db-1 only", history.Entries)
+	}
+
+	if n := history.Forget("web-1"); n != 0 {
+		t.Errorf("Forget() again = %d", n)
+	}
+}
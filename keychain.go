@@ -1,3 +1,5 @@
+//go:build darwin
+
 package main
 
 import (
@@ -6,12 +8,25 @@ import (
 	"github.com/keybase/go-keychain"
 )
 
-type Auth struct {
-	Password string `json:"password"`
-	Secret   string `json:"secret"`
+type KeychainStore struct {
+	account string
+}
+
+// NewSystemAuthStore returns the keychain item for account, "" is the item
+// used before auth was stored per profile.
+func NewSystemAuthStore(account string) AuthStore {
+	if account == "" {
+		account = "tssh"
+	}
+
+	return KeychainStore{account}
+}
+
+func (KeychainStore) Name() string {
+	return "keychain"
 }
 
-func StoreAuth(auth Auth) error {
+func (s KeychainStore) Store(auth Auth) error {
 	data, err := json.Marshal(auth)
 	if err != nil {
 		return err
@@ -20,20 +35,33 @@ func StoreAuth(auth Auth) error {
 	item := keychain.NewItem()
 	item.SetSecClass(keychain.SecClassGenericPassword)
 	item.SetService("tssh")
-	item.SetAccount("tssh")
+	item.SetAccount(s.account)
 	item.SetLabel("Teleport Login for tssh")
 	item.SetData(data)
 	item.SetSynchronizable(keychain.SynchronizableNo)
 	item.SetAccessible(keychain.AccessibleWhenUnlockedThisDeviceOnly)
 
-	return keychain.AddItem(item)
+	err = keychain.AddItem(item)
+	if err != keychain.ErrorDuplicateItem {
+		return err
+	}
+
+	query := keychain.NewItem()
+	query.SetSecClass(keychain.SecClassGenericPassword)
+	query.SetService("tssh")
+	query.SetAccount(s.account)
+
+	update := keychain.NewItem()
+	update.SetData(data)
+
+	return keychain.UpdateItem(query, update)
 }
 
-func GetAuth() (*Auth, error) {
+func (s KeychainStore) Get() (*Auth, error) {
 	query := keychain.NewItem()
 	query.SetSecClass(keychain.SecClassGenericPassword)
 	query.SetService("tssh")
-	query.SetAccount("tssh")
+	query.SetAccount(s.account)
 	query.SetMatchLimit(keychain.MatchLimitOne)
 	query.SetReturnData(true)
 	results, err := keychain.QueryItem(query)
@@ -55,10 +83,10 @@ func GetAuth() (*Auth, error) {
 	}
 }
 
-func DeleteAuth() error {
+func (s KeychainStore) Delete() error {
 	item := keychain.NewItem()
 	item.SetSecClass(keychain.SecClassGenericPassword)
 	item.SetService("tssh")
-	item.SetAccount("tssh")
+	item.SetAccount(s.account)
 	return keychain.DeleteItem(item)
 }
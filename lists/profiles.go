@@ -0,0 +1,81 @@
+package lists
+
+import (
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type ProfileSelectedMsg struct {
+	Profile string
+}
+
+type ProfilesListModel struct {
+	index int
+
+	profiles []string
+}
+
+func InitProfilesListModel() ProfilesListModel {
+	return ProfilesListModel{
+		profiles: []string{},
+	}
+}
+
+func (m ProfilesListModel) SetProfiles(profiles []string, current string) ProfilesListModel {
+	m.profiles = profiles
+	m.index = max(slices.Index(profiles, current), 0)
+
+	return m
+}
+
+func (m ProfilesListModel) Update(msg tea.Msg) (ProfilesListModel, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "down", "tab":
+			m.index += 1
+			if m.index >= len(m.profiles) {
+				m.index = 0
+			}
+		case "up":
+			m.index -= 1
+			if m.index < 0 {
+				m.index = len(m.profiles) - 1
+			}
+		case "enter":
+			if len(m.profiles) == 0 {
+				return m, nil
+			}
+
+			return m, func() tea.Msg { return ProfileSelectedMsg{m.profiles[m.index]} }
+		}
+	}
+
+	return m, nil
+}
+
+func (m ProfilesListModel) View() string {
+	builder := strings.Builder{}
+
+	limit := min(len(m.profiles), 10)
+	from := 0
+	if m.index > (limit / 2) {
+		from = m.index - (limit / 2)
+		from = min(from, len(m.profiles)-limit)
+	}
+
+	for i, profile := range m.profiles[from : from+limit] {
+		if m.index == from+i {
+			builder.WriteString("> " + profile)
+		} else {
+			builder.WriteString(itemStyle.Render(normalItemStyle.Render(profile)))
+		}
+
+		if i != limit {
+			builder.WriteRune('\n')
+		}
+	}
+
+	return builder.String()
+}
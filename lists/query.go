@@ -0,0 +1,141 @@
+package lists
+
+import (
+	"slices"
+	"strings"
+)
+
+// labelFilter is a "key=value" (or "key:value") token of the search query.
+// A leading "-" negates it and an empty value only asks for the label to be
+// set. The "cluster" key matches the cluster of the server.
+type labelFilter struct {
+	key    string
+	value  string
+	negate bool
+}
+
+// splitArgs cuts the search box value at "--", the words after it are passed
+// to tsh ssh.
+func splitArgs(value string) (string, []string) {
+	fields := strings.Fields(value)
+
+	i := slices.Index(fields, "--")
+	if i < 0 {
+		return value, nil
+	}
+
+	return strings.Join(fields[:i], " "), fields[i+1:]
+}
+
+// parseQuery splits the query into label filters and the words to fuzzy
+// match against hostnames.
+func parseQuery(query string) ([]labelFilter, []string) {
+	filters := []labelFilter{}
+	words := []string{}
+
+	for _, token := range strings.Fields(query) {
+		negate := strings.HasPrefix(token, "-")
+		body := strings.TrimPrefix(token, "-")
+
+		i := strings.IndexAny(body, "=:")
+		if i <= 0 {
+			words = append(words, token)
+
+			continue
+		}
+
+		filters = append(filters, labelFilter{
+			key:    body[:i],
+			value:  body[i+1:],
+			negate: negate,
+		})
+	}
+
+	return filters, words
+}
+
+func (f labelFilter) label(server Server) (string, bool) {
+	if f.key == "cluster" {
+		return server.Cluster, true
+	}
+
+	value, ok := server.Labels[f.key]
+
+	return value, ok
+}
+
+func (f labelFilter) matches(server Server) bool {
+	value, ok := f.label(server)
+	matched := ok && (f.value == "" || value == f.value)
+
+	return matched != f.negate
+}
+
+// labelIndex lists the label keys of servers with their values, sorted, for
+// autocompletion.
+func labelIndex(servers []Server) map[string][]string {
+	index := map[string][]string{}
+
+	for _, server := range servers {
+		if server.Cluster != "" && !slices.Contains(index["cluster"], server.Cluster) {
+			index["cluster"] = append(index["cluster"], server.Cluster)
+		}
+
+		for key, value := range server.Labels {
+			if !slices.Contains(index[key], value) {
+				index[key] = append(index[key], value)
+			}
+		}
+	}
+
+	for _, values := range index {
+		slices.Sort(values)
+	}
+
+	return index
+}
+
+// querySuggestions completes the last token of query: a label key while it
+// has no separator yet, a value of that key afterwards. Suggestions are whole
+// queries, that is what textinput completes.
+func querySuggestions(query string, index map[string][]string) []string {
+	// Nothing after "--" is a label.
+	if slices.Contains(strings.Fields(query), "--") {
+		return nil
+	}
+
+	token := query[strings.LastIndexAny(query, " ")+1:]
+	prefix := query[:len(query)-len(token)]
+
+	if strings.HasPrefix(token, "-") {
+		prefix += "-"
+		token = token[1:]
+	}
+
+	if token == "" {
+		return nil
+	}
+
+	suggestions := []string{}
+
+	if i := strings.IndexAny(token, "=:"); i > 0 {
+		for _, value := range index[token[:i]] {
+			suggestions = append(suggestions, prefix+token[:i+1]+value)
+		}
+
+		return suggestions
+	}
+
+	keys := make([]string, 0, len(index))
+	for key := range index {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		suggestions = append(suggestions, prefix+key+"=")
+	}
+
+	return suggestions
+}
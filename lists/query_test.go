@@ -0,0 +1,105 @@
+package lists
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		filters []labelFilter
+		words   []string
+	}{
+		{
+			query:   "web prod",
+			filters: []labelFilter{},
+			words:   []string{"web", "prod"},
+		},
+		{
+			query:   "env=prod web",
+			filters: []labelFilter{{key: "env", value: "prod"}},
+			words:   []string{"web"},
+		},
+		{
+			query:   "team:db -env=staging",
+			filters: []labelFilter{{key: "team", value: "db"}, {key: "env", value: "staging", negate: true}},
+			words:   []string{},
+		},
+		{
+			query:   "gpu= -os:",
+			filters: []labelFilter{{key: "gpu"}, {key: "os", negate: true}},
+			words:   []string{},
+		},
+		{
+			query:   "=prod -web :x",
+			filters: []labelFilter{},
+			words:   []string{"=prod", "-web", ":x"},
+		},
+		{
+			query:   "  ",
+			filters: []labelFilter{},
+			words:   []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			filters, words := parseQuery(tt.query)
+
+			if !reflect.DeepEqual(filters, tt.filters) || !reflect.DeepEqual(words, tt.words) {
+				t.Errorf("parseQuery(%q) = %+v, %q, want %+v, %q", tt.query, filters, words, tt.filters, tt.words)
+			}
+		})
+	}
+}
+
+func TestLabelFilterMatches(t *testing.T) {
+	server := Server{
+		Hostname: "web-1",
+		Cluster:  "leaf",
+		Labels:   map[string]string{"env": "prod", "team": ""},
+	}
+
+	tests := []struct {
+		filter labelFilter
+		want   bool
+	}{
+		{labelFilter{key: "env", value: "prod"}, true},
+		{labelFilter{key: "env", value: "staging"}, false},
+		{labelFilter{key: "env"}, true},
+		{labelFilter{key: "team"}, true},
+		{labelFilter{key: "os"}, false},
+		{labelFilter{key: "env", value: "prod", negate: true}, false},
+		{labelFilter{key: "env", value: "staging", negate: true}, true},
+		{labelFilter{key: "os", negate: true}, true},
+		{labelFilter{key: "cluster", value: "leaf"}, true},
+		{labelFilter{key: "cluster", value: "root"}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.matches(server); got != tt.want {
+			t.Errorf("%+v.matches() = %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		value string
+		query string
+		args  []string
+	}{
+		{"web", "web", nil},
+		{"web -- -A uptime", "web", []string{"-A", "uptime"}},
+		{"web --", "web", []string{}},
+		{"-- ls", "", []string{"ls"}},
+	}
+
+	for _, tt := range tests {
+		query, args := splitArgs(tt.value)
+		if query != tt.query || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitArgs(%q) = %q, %q, want %q, %q", tt.value, query, args, tt.query, tt.args)
+		}
+	}
+}
@@ -9,8 +9,44 @@ import (
 	"github.com/sahilm/fuzzy"
 )
 
+// Server is a row of the servers list.
+type Server struct {
+	UUID     string
+	Hostname string
+	Cluster  string
+	Labels   map[string]string
+
+	// Login is the login the server will be connected to with.
+	Login string
+
+	// Frecency ranks servers used often and recently first, it is added to
+	// the fuzzy score.
+	Frecency int
+
+	// Favourite servers come first, their alias matches them exactly.
+	Favourite bool
+	Alias     string
+}
+
+// ServerSelectedMsg carries the tsh ssh arguments typed after "--" in the
+// search box with the selected server.
 type ServerSelectedMsg struct {
+	UUID     string
 	Hostname string
+	Cluster  string
+	Args     []string
+}
+
+// serverSource lets fuzzy match hostnames while keeping the index into the
+// servers they belong to.
+type serverSource []Server
+
+func (s serverSource) String(i int) string {
+	return s[i].Hostname
+}
+
+func (s serverSource) Len() int {
+	return len(s)
 }
 
 type ServersListModel struct {
@@ -20,39 +56,314 @@ type ServersListModel struct {
 
 	matchesIndex int
 
-	servers             []string
-	recentlyUsedServers [10]string
-	matches             fuzzy.Matches
+	servers      []Server
+	candidates   []Server
+	matches      fuzzy.Matches
+	filters      []labelFilter
+	labels       map[string][]string
+	showClusters bool
 }
 
 func InitServersListModel() ServersListModel {
 	filterInput := textinput.New()
 	filterInput.Prompt = "> "
-	filterInput.Placeholder = "host.example.com"
-	filterInput.CharLimit = 64
+	filterInput.Placeholder = "host.example.com env=prod"
+	filterInput.CharLimit = 256
+	filterInput.ShowSuggestions = true
 	filterInput.Focus()
 
 	return ServersListModel{
 		panel:       "filter",
 		filterInput: filterInput,
-		servers:     []string{},
+		servers:     []Server{},
 	}
 }
 
-func (m ServersListModel) SetServers(servers []string, recentlyUsedServers [10]string) ServersListModel {
+func (m ServersListModel) SetServers(servers []Server) ServersListModel {
 	m.panel = "filter"
-	m.servers = servers
-	m.recentlyUsedServers = recentlyUsedServers
+	m.servers = byFrecency(servers)
 	m.matchesIndex = 0
+	m.labels = labelIndex(servers)
 	m.filterInput.Focus()
+	m.filterInput.SetSuggestions(querySuggestions(m.filterInput.Value(), m.labels))
+
+	m.showClusters = severalClusters(servers)
 
 	if m.filterInput.Value() != "" {
-		m.matches = fuzzy.Find(m.filterInput.Value(), m.servers)
+		m = m.filter()
+	}
+
+	return m
+}
+
+// RefreshServers replaces the servers in place: the query, the panel and the
+// selected server, when it is still there, are kept.
+func (m ServersListModel) RefreshServers(servers []Server) ServersListModel {
+	var selected *Server
+	if m.panel == "list" && m.matchesIndex < len(m.matches) {
+		server := m.candidates[m.matches[m.matchesIndex].Index]
+		selected = &server
+	}
+
+	m.servers = byFrecency(servers)
+	m.labels = labelIndex(servers)
+	m.showClusters = severalClusters(servers)
+	m.filterInput.SetSuggestions(querySuggestions(m.filterInput.Value(), m.labels))
+
+	if m.filterInput.Value() == "" {
+		return m
+	}
+
+	m = m.filter()
+	m.matchesIndex = 0
+
+	if selected != nil {
+		index := slices.IndexFunc(m.matches, func(match fuzzy.Match) bool {
+			server := m.candidates[match.Index]
+
+			return server.UUID == selected.UUID && server.Hostname == selected.Hostname && server.Cluster == selected.Cluster
+		})
+
+		if index >= 0 {
+			m.matchesIndex = index
+		}
+	}
+
+	if m.panel == "list" && len(m.matches) == 0 {
+		m.panel = "filter"
+		m.filterInput.Focus()
+	}
+
+	return m
+}
+
+// SetQuery types query into the search box.
+func (m ServersListModel) SetQuery(query string) ServersListModel {
+	m.panel = "filter"
+	m.matchesIndex = 0
+	m.filterInput.Focus()
+	m.filterInput.SetValue(query)
+	m.filterInput.CursorEnd()
+	m.filterInput.SetSuggestions(querySuggestions(query, m.labels))
+
+	if query != "" {
+		m = m.filter()
+	}
+
+	return m
+}
+
+// Match returns the servers query matches, in the order the list shows them.
+func Match(servers []Server, query string) []Server {
+	m := InitServersListModel().SetServers(servers).SetQuery(query)
+	if strings.TrimSpace(query) == "" {
+		return m.servers
+	}
+
+	matched := make([]Server, 0, len(m.matches))
+	for _, match := range m.matches {
+		matched = append(matched, m.candidates[match.Index])
+	}
+
+	return matched
+}
+
+// DirectMatch returns the server the query names on its own: the one with
+// the query as alias or hostname, or the only match. The number of matches
+// tells an unknown server from an ambiguous one.
+func (m ServersListModel) DirectMatch() (Server, int) {
+	if len(m.matches) == 0 {
+		return Server{}, 0
+	}
+
+	query, _ := splitArgs(m.filterInput.Value())
+	if len(m.matches) == 1 || m.isAlias(query) {
+		return m.candidates[m.matches[0].Index], 1
+	}
+
+	_, words := parseQuery(query)
+	if len(words) != 1 {
+		return Server{}, len(m.matches)
+	}
+
+	exact := []Server{}
+	for _, match := range m.matches {
+		if m.candidates[match.Index].Hostname == words[0] {
+			exact = append(exact, m.candidates[match.Index])
+		}
+	}
+
+	if len(exact) == 1 {
+		return exact[0], 1
+	}
+
+	return Server{}, len(m.matches)
+}
+
+func severalClusters(servers []Server) bool {
+	for _, server := range servers {
+		if server.Cluster != servers[0].Cluster {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filter applies the query: label filters keep the servers they match, the
+// rest of the query is fuzzy matched against hostnames.
+func (m ServersListModel) filter() ServersListModel {
+	query, _ := splitArgs(m.filterInput.Value())
+	filters, words := parseQuery(query)
+	m.filters = filters
+
+	m.candidates = m.servers
+	if len(filters) != 0 {
+		m.candidates = slices.DeleteFunc(slices.Clone(m.servers), func(server Server) bool {
+			for _, f := range filters {
+				if !f.matches(server) {
+					return true
+				}
+			}
+
+			return false
+		})
+	}
+
+	if len(words) != 0 {
+		m.matches = fuzzy.FindFrom(strings.Join(words, " "), serverSource(m.candidates))
+	} else {
+		m.matches = make(fuzzy.Matches, 0, len(m.candidates))
+		for i, server := range m.candidates {
+			m.matches = append(m.matches, fuzzy.Match{Str: server.Hostname, Index: i})
+		}
+	}
+
+	slices.SortStableFunc(m.matches, func(a fuzzy.Match, b fuzzy.Match) int {
+		return (b.Score + m.candidates[b.Index].Frecency) - (a.Score + m.candidates[a.Index].Frecency)
+	})
+
+	if len(words) == 1 {
+		m = m.aliasFirst(words[0])
 	}
 
 	return m
 }
 
+// aliasFirst puts the server with the alias at the top, even when its
+// hostname doesn't fuzzy match the alias.
+func (m ServersListModel) aliasFirst(alias string) ServersListModel {
+	i := slices.IndexFunc(m.candidates, func(server Server) bool {
+		return server.Alias != "" && server.Alias == alias
+	})
+	if i < 0 {
+		return m
+	}
+
+	m.matches = slices.DeleteFunc(m.matches, func(match fuzzy.Match) bool {
+		return match.Index == i
+	})
+	m.matches = slices.Insert(m.matches, 0, fuzzy.Match{Str: m.candidates[i].Hostname, Index: i})
+
+	return m
+}
+
+func (m ServersListModel) isAlias(query string) bool {
+	return len(m.matches) != 0 && m.candidates[m.matches[0].Index].Alias == strings.TrimSpace(query)
+}
+
+func byFrecency(servers []Server) []Server {
+	servers = slices.Clone(servers)
+	slices.SortStableFunc(servers, func(a Server, b Server) int {
+		if a.Favourite != b.Favourite {
+			if a.Favourite {
+				return -1
+			}
+
+			return 1
+		}
+
+		return b.Frecency - a.Frecency
+	})
+
+	return servers
+}
+
+func (m ServersListModel) selected(match fuzzy.Match) tea.Cmd {
+	server := m.candidates[match.Index]
+
+	_, args := splitArgs(m.filterInput.Value())
+
+	return func() tea.Msg { return ServerSelectedMsg{server.UUID, server.Hostname, server.Cluster, args} }
+}
+
+// Highlighted returns the server enter would connect to.
+func (m ServersListModel) Highlighted() (Server, bool) {
+	if m.panel == "list" && m.matchesIndex < len(m.matches) {
+		return m.candidates[m.matches[m.matchesIndex].Index], true
+	}
+
+	if m.panel == "filter" && len(m.matches) != 0 {
+		return m.candidates[m.matches[0].Index], true
+	}
+
+	return Server{}, false
+}
+
+func loginPrefix(server Server) string {
+	if server.Login == "" {
+		return ""
+	}
+
+	return normalItemStyle.Render(server.Login + "@")
+}
+
+// canComplete tells whether tab should accept the label suggestion instead
+// of moving to the list.
+func (m ServersListModel) canComplete() bool {
+	suggestion := m.filterInput.CurrentSuggestion()
+
+	return suggestion != "" && suggestion != m.filterInput.Value()
+}
+
+// labelTags highlights the labels the query filtered on.
+func (m ServersListModel) labelTags(server Server) string {
+	tags := strings.Builder{}
+
+	for _, f := range m.filters {
+		if f.negate || f.key == "cluster" {
+			continue
+		}
+
+		if value, ok := server.Labels[f.key]; ok {
+			tags.WriteString(foundItemStyle.Render(" " + f.key + "=" + value))
+		}
+	}
+
+	return tags.String()
+}
+
+// clusterTag shows which cluster a server is in once there is more than one.
+func (m ServersListModel) clusterTag(server Server) string {
+	if !m.showClusters || server.Cluster == "" {
+		return ""
+	}
+
+	return normalItemStyle.Render(" (" + server.Cluster + ")")
+}
+
+// favouriteTag marks favourites and shows their alias.
+func favouriteTag(server Server) string {
+	switch {
+	case server.Alias != "":
+		return foundItemStyle.Render(" ★ " + server.Alias)
+	case server.Favourite:
+		return foundItemStyle.Render(" ★")
+	}
+
+	return ""
+}
+
 func (m ServersListModel) Update(msg tea.Msg) (ServersListModel, tea.Cmd) {
 	var cmd tea.Cmd
 
@@ -60,15 +371,20 @@ func (m ServersListModel) Update(msg tea.Msg) (ServersListModel, tea.Cmd) {
 		if msg, ok := msg.(tea.KeyMsg); ok {
 			switch msg.String() {
 			case "enter", "tab":
+				if msg.String() == "tab" && m.canComplete() {
+					break
+				}
+
 				if m.filterInput.Value() != "" {
 					if len(m.matches) == 0 {
 						return m, tea.Quit
 					}
 
-					if len(m.matches) == 1 {
+					query, _ := splitArgs(m.filterInput.Value())
+					if len(m.matches) == 1 || m.isAlias(query) {
 						m.panel = "empty"
 
-						return m, func() tea.Msg { return ServerSelectedMsg{m.matches[0].Str} }
+						return m, m.selected(m.matches[0])
 					}
 
 					m.panel = "list"
@@ -80,21 +396,13 @@ func (m ServersListModel) Update(msg tea.Msg) (ServersListModel, tea.Cmd) {
 		}
 
 		m.filterInput, cmd = m.filterInput.Update(msg)
+		m.filterInput.SetSuggestions(querySuggestions(m.filterInput.Value(), m.labels))
 
 		if m.filterInput.Value() != "" {
-			m.matches = fuzzy.Find(m.filterInput.Value(), m.servers)
-
-			for _, s := range slices.Backward(m.recentlyUsedServers[:]) {
-				index := slices.IndexFunc(m.matches, func(match fuzzy.Match) bool {
-					return match.Str == s
-				})
-
-				if index >= 0 {
-					for i := index; i > 0; i-- {
-						m.matches.Swap(i, i-1)
-					}
-				}
-			}
+			m = m.filter()
+		} else {
+			m.matches = nil
+			m.filters = nil
 		}
 
 		return m, cmd
@@ -116,7 +424,7 @@ func (m ServersListModel) Update(msg tea.Msg) (ServersListModel, tea.Cmd) {
 			case "enter":
 				m.panel = "empty"
 
-				return m, func() tea.Msg { return ServerSelectedMsg{m.matches[m.matchesIndex].Str} }
+				return m, m.selected(m.matches[m.matchesIndex])
 			}
 		}
 	}
@@ -144,6 +452,7 @@ func (m ServersListModel) View() string {
 
 				for i, match := range m.matches[:limit] {
 					word := strings.Builder{}
+					word.WriteString(loginPrefix(m.candidates[match.Index]))
 
 					for j := 0; j < len(match.Str); j++ {
 						if slices.Contains(match.MatchedIndexes, j) {
@@ -153,6 +462,10 @@ func (m ServersListModel) View() string {
 						}
 					}
 
+					word.WriteString(favouriteTag(m.candidates[match.Index]))
+					word.WriteString(m.labelTags(m.candidates[match.Index]))
+					word.WriteString(m.clusterTag(m.candidates[match.Index]))
+
 					builder.WriteString(itemStyle.Render(word.String()))
 
 					if i != limit {
@@ -163,7 +476,7 @@ func (m ServersListModel) View() string {
 				limit := min(len(m.servers), 10)
 
 				for i, server := range m.servers[:limit] {
-					builder.WriteString(itemStyle.Render(normalItemStyle.Render(server)))
+					builder.WriteString(itemStyle.Render(loginPrefix(server) + normalItemStyle.Render(server.Hostname) + favouriteTag(server) + m.clusterTag(server)))
 
 					if i != 9 {
 						builder.WriteRune('\n')
@@ -183,6 +496,7 @@ func (m ServersListModel) View() string {
 
 		for i, match := range m.matches[from : from+limit] {
 			word := strings.Builder{}
+			word.WriteString(loginPrefix(m.candidates[match.Index]))
 
 			for j := 0; j < len(match.Str); j++ {
 				if slices.Contains(match.MatchedIndexes, j) {
@@ -196,6 +510,10 @@ func (m ServersListModel) View() string {
 				}
 			}
 
+			word.WriteString(favouriteTag(m.candidates[match.Index]))
+			word.WriteString(m.labelTags(m.candidates[match.Index]))
+			word.WriteString(m.clusterTag(m.candidates[match.Index]))
+
 			if m.matchesIndex == from+i {
 				builder.WriteString("> " + word.String())
 			} else {
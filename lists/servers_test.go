@@ -0,0 +1,60 @@
+package lists
+
+import "testing"
+
+var testServers = []Server{
+	{Hostname: "web-1", Cluster: "root"},
+	{Hostname: "web-10", Cluster: "root"},
+	{Hostname: "db-primary", Cluster: "root", Favourite: true, Alias: "prod"},
+	{Hostname: "api", Cluster: "root"},
+	{Hostname: "api", Cluster: "leaf"},
+	{Hostname: "cache-1", Cluster: "leaf", Labels: map[string]string{"env": "staging"}},
+}
+
+func TestDirectMatch(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    string
+		matches int
+	}{
+		{query: "web-1", want: "web-1", matches: 1},
+		{query: "web-10", want: "web-10", matches: 1},
+		{query: "web", matches: 2},
+		{query: "prod", want: "db-primary", matches: 1},
+		{query: "prod -- uptime", want: "db-primary", matches: 1},
+		{query: "cache", want: "cache-1", matches: 1},
+		{query: "env=staging", want: "cache-1", matches: 1},
+		{query: "api", matches: 2},
+		{query: "api cluster:leaf", matches: 1, want: "api"},
+		{query: "nothing", matches: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			m := InitServersListModel().SetServers(testServers).SetQuery(tt.query)
+
+			server, matches := m.DirectMatch()
+			if server.Hostname != tt.want || matches != tt.matches {
+				t.Errorf("DirectMatch() = %q, %d, want %q, %d", server.Hostname, matches, tt.want, tt.matches)
+			}
+		})
+	}
+}
+
+// The alias finds its server first even when the hostname doesn't fuzzy
+// match it.
+func TestMatchAliasFirst(t *testing.T) {
+	servers := append([]Server{{Hostname: "prod-web"}, {Hostname: "prod-db"}}, testServers...)
+
+	matched := Match(servers, "prod")
+	if len(matched) != 3 || matched[0].Hostname != "db-primary" {
+		t.Errorf("Match() = %+v, want db-primary first, then prod-web and prod-db", matched)
+	}
+
+	matched = Match(servers, "pro")
+	for _, server := range matched {
+		if server.Hostname == "db-primary" {
+			t.Errorf("Match() = %+v, a partial alias is not an alias", matched)
+		}
+	}
+}
@@ -24,6 +24,9 @@ func InitUsersListModel() UsersListModel {
 
 func (m UsersListModel) SetUsers(users []string) UsersListModel {
 	m.users = users
+	if m.index >= len(users) {
+		m.index = 0
+	}
 
 	return m
 }
@@ -1,47 +1,74 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
+	"context"
 	"errors"
-	"image"
-	"net/url"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/makiuchi-d/gozxing"
-	"github.com/makiuchi-d/gozxing/qrcode"
-	"github.com/pquerna/otp/totp"
 )
 
+const secretLabel = "Secret (OTP secret, otpauth:// URI, QR code image path, data:image/png;base64 string or 'clipboard'):"
+
 var (
 	blurredStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+	errorStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
 	noStyle      = lipgloss.NewStyle()
 )
 
 type SubmitMsg struct{}
 
+type VerifiedMsg struct {
+	attempts int
+	err      error
+}
+
 type LoginModel struct {
 	stage string
 
+	profile  Profile
+	store    AuthStore
+	verify   bool
+	existing *Auth
+
 	focusIndex    int
 	passwordInput textinput.Model
 	secretInput   textinput.Model
 
-	secret string
-	code   string
+	otp  Auth
+	code string
+
+	spinner spinner.Model
+
+	// cancelVerify kills the 'tsh login' checking the auth, quitting waits
+	// for it to exit.
+	cancelVerify context.CancelFunc
+	quitting     bool
+
+	err error
 }
 
-func InitLoginModel() LoginModel {
+// InitLoginModel creates the login form. When existing is not nil the form is
+// pre-filled with it, so either field can be changed on its own.
+func InitLoginModel(profile Profile, store AuthStore, verify bool, existing *Auth) LoginModel {
 	m := LoginModel{
 		stage:         "edit",
+		profile:       profile,
+		store:         store,
+		verify:        verify,
+		existing:      existing,
 		passwordInput: textinput.New(),
 		secretInput:   textinput.New(),
+		spinner:       spinner.New(),
 	}
 
+	m.spinner.Spinner = spinner.Line
+	m.spinner.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("69"))
+
 	m.passwordInput.EchoMode = textinput.EchoPassword
 	m.passwordInput.EchoCharacter = '•'
 
@@ -56,6 +83,11 @@ func InitLoginModel() LoginModel {
 	m.secretInput.PromptStyle = blurredStyle
 	m.secretInput.TextStyle = blurredStyle
 
+	if existing != nil {
+		m.passwordInput.SetValue(existing.Password)
+		m.secretInput.SetValue(existing.Secret)
+	}
+
 	return m
 }
 
@@ -83,7 +115,22 @@ func (m LoginModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				s := msg.String()
 
 				if s == "enter" && m.focusIndex == 1 {
+					otpAuth, err := m.parseSecret()
+					if err != nil {
+						m.err = err
+
+						return m, nil
+					}
+
+					code, err := otpAuth.Code(time.Now())
+					if err != nil {
+						m.err = err
+
+						return m, nil
+					}
+
 					m.stage = "submit"
+					m.err = nil
 
 					m.secretInput.PromptStyle = blurredStyle
 					m.secretInput.TextStyle = blurredStyle
@@ -93,48 +140,7 @@ func (m LoginModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.passwordInput.TextStyle = blurredStyle
 					m.passwordInput.Blur()
 
-					secret := m.secretInput.Value()
-
-					if strings.HasPrefix(secret, "data:image/png;base64,") {
-						imageRaw, err := base64.StdEncoding.DecodeString(secret[22:])
-						if err != nil {
-							return m, ErrorMsg(err)
-						}
-
-						image, _, err := image.Decode(bytes.NewReader(imageRaw))
-						if err != nil {
-							return m, ErrorMsg(err)
-						}
-
-						bmp, err := gozxing.NewBinaryBitmapFromImage(image)
-						if err != nil {
-							return m, ErrorMsg(err)
-						}
-
-						qrReader := qrcode.NewQRCodeReader()
-						result, err := qrReader.Decode(bmp, nil)
-						if err != nil {
-							return m, ErrorMsg(err)
-						}
-
-						otpauth, err := url.Parse(result.GetText())
-						if err != nil {
-							return m, ErrorMsg(err)
-						}
-
-						secret = otpauth.Query().Get("secret")
-					}
-
-					if len(secret) == 0 {
-						return m, ErrorMsg(errors.New("no secret in url"))
-					}
-
-					code, err := totp.GenerateCode(secret, time.Now())
-					if err != nil {
-						return m, ErrorMsg(err)
-					}
-
-					m.secret = secret
+					m.otp = otpAuth
 					m.code = code
 
 					return m, nil
@@ -152,29 +158,7 @@ func (m LoginModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.focusIndex = 1
 				}
 
-				if m.focusIndex == 0 {
-					cmd := m.passwordInput.Focus()
-					m.passwordInput.PromptStyle = noStyle
-					m.passwordInput.TextStyle = noStyle
-
-					m.secretInput.Blur()
-					m.secretInput.PromptStyle = blurredStyle
-					m.secretInput.TextStyle = blurredStyle
-
-					return m, cmd
-				}
-
-				if m.focusIndex == 1 {
-					cmd := m.secretInput.Focus()
-					m.secretInput.PromptStyle = noStyle
-					m.secretInput.TextStyle = noStyle
-
-					m.passwordInput.Blur()
-					m.passwordInput.PromptStyle = blurredStyle
-					m.passwordInput.TextStyle = blurredStyle
-
-					return m, cmd
-				}
+				return m.focus(m.focusIndex)
 			}
 		}
 
@@ -187,6 +171,10 @@ func (m LoginModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		if m.focusIndex == 1 {
+			if _, ok := msg.(tea.KeyMsg); ok {
+				m.err = nil
+			}
+
 			m.secretInput, cmd = m.secretInput.Update(msg)
 
 			return m, cmd
@@ -200,7 +188,7 @@ func (m LoginModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "ctrl+c", "esc":
 				return m, tea.Quit
 			case "r":
-				code, err := totp.GenerateCode(m.secret, time.Now())
+				code, err := m.otp.Code(time.Now())
 				if err != nil {
 					return m, ErrorMsg(err)
 				}
@@ -209,29 +197,141 @@ func (m LoginModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 				return m, nil
 			case "enter":
-				err := StoreAuth(Auth{
-					Password: m.passwordInput.Value(),
-					Secret:   m.secret,
-				})
-				if err != nil {
-					return m, ErrorMsg(err)
+				auth := m.otp
+				auth.Password = m.passwordInput.Value()
+
+				if m.verify {
+					ctx, cancel := context.WithCancel(context.Background())
+
+					m.stage = "verify"
+					m.err = nil
+					m.cancelVerify = cancel
+
+					return m, tea.Batch(
+						m.spinner.Tick,
+						func() tea.Msg {
+							attempts, err := TshLogin(ctx, m.profile, &auth)
+
+							return VerifiedMsg{attempts, err}
+						},
+					)
 				}
 
-				m.stage = "success"
+				return m.storeAuth()
+			}
+
+		}
+	}
 
+	if m.stage == "verify" {
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "ctrl+c", "esc":
+				// Quits once the killed 'tsh login' has exited.
+				m.cancelVerify()
+				m.quitting = true
+
+				return m, nil
+			}
+		case VerifiedMsg:
+			m.cancelVerify()
+
+			if m.quitting {
 				return m, tea.Quit
 			}
 
+			if msg.err != nil {
+				// Back to the form so a typo can be fixed right away, in the
+				// secret when tsh rejected the code.
+				m.stage = "edit"
+				m.err = msg.err
+
+				if errors.Is(msg.err, errOTPRejected) {
+					return m.focus(1)
+				}
+
+				return m.focus(0)
+			}
+
+			return m.storeAuth()
 		}
+
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+
+		return m, cmd
 	}
 
 	return m, nil
 }
 
+// focus moves the cursor to the input at index, 0 for the password and 1 for
+// the secret, and dims the other one.
+func (m LoginModel) focus(index int) (LoginModel, tea.Cmd) {
+	m.focusIndex = index
+
+	if index == 0 {
+		cmd := m.passwordInput.Focus()
+		m.passwordInput.PromptStyle = noStyle
+		m.passwordInput.TextStyle = noStyle
+
+		m.secretInput.Blur()
+		m.secretInput.PromptStyle = blurredStyle
+		m.secretInput.TextStyle = blurredStyle
+
+		return m, cmd
+	}
+
+	cmd := m.secretInput.Focus()
+	m.secretInput.PromptStyle = noStyle
+	m.secretInput.TextStyle = noStyle
+
+	m.passwordInput.Blur()
+	m.passwordInput.PromptStyle = blurredStyle
+	m.passwordInput.TextStyle = blurredStyle
+
+	return m, cmd
+}
+
+// parseSecret keeps the stored OTP parameters when the pre-filled secret was
+// left unchanged.
+func (m LoginModel) parseSecret() (Auth, error) {
+	if m.existing != nil && m.existing.Secret != "" && m.secretInput.Value() == m.existing.Secret {
+		otpAuth := *m.existing
+		otpAuth.Password = ""
+
+		return otpAuth, nil
+	}
+
+	return ParseOTPSecret(m.secretInput.Value())
+}
+
+func (m LoginModel) storeAuth() (tea.Model, tea.Cmd) {
+	auth := m.otp
+	auth.Password = m.passwordInput.Value()
+	auth.UpdatedAt = time.Now()
+
+	err := m.store.Store(auth)
+	if err != nil {
+		return m, ErrorMsg(err)
+	}
+
+	m.stage = "success"
+
+	return m, tea.Quit
+}
+
 func (m LoginModel) View() string {
 	var b strings.Builder
 
 	if m.stage == "edit" {
+		if m.existing != nil {
+			b.WriteString(blurredStyle.Render("Editing stored auth, leave a field as is to keep it"))
+			b.WriteRune('\n')
+			b.WriteRune('\n')
+		}
+
 		if m.passwordInput.Focused() {
 			b.WriteString(noStyle.Render("Password:"))
 			b.WriteRune('\n')
@@ -245,13 +345,18 @@ func (m LoginModel) View() string {
 		b.WriteRune('\n')
 
 		if m.secretInput.Focused() {
-			b.WriteString(noStyle.Render("Secret (OTP secret or data:image/png;base64 QRcode string):"))
+			b.WriteString(noStyle.Render(secretLabel))
 			b.WriteRune('\n')
 		} else {
-			b.WriteString(blurredStyle.Render("Secret (OTP secret or data:image/png;base64 QRcode string):"))
+			b.WriteString(blurredStyle.Render(secretLabel))
 			b.WriteRune('\n')
 		}
 		b.WriteString(m.secretInput.View())
+
+		if m.err != nil {
+			b.WriteRune('\n')
+			b.WriteString(errorStyle.Render(m.err.Error()))
+		}
 	}
 
 	if m.stage == "submit" {
@@ -262,7 +367,7 @@ func (m LoginModel) View() string {
 		b.WriteRune('\n')
 		b.WriteRune('\n')
 
-		b.WriteString(blurredStyle.Render("Secret (OTP secret or data:image/png;base64 QRcode string):"))
+		b.WriteString(blurredStyle.Render(secretLabel))
 		b.WriteRune('\n')
 		b.WriteString(m.secretInput.View())
 
@@ -278,6 +383,30 @@ func (m LoginModel) View() string {
 		b.WriteString(blurredStyle.Render("press r to update otp code"))
 	}
 
+	if m.stage == "verify" {
+		b.WriteString(blurredStyle.Render("Password:"))
+		b.WriteRune('\n')
+		b.WriteString(m.passwordInput.View())
+
+		b.WriteRune('\n')
+		b.WriteRune('\n')
+
+		b.WriteString(blurredStyle.Render(secretLabel))
+		b.WriteRune('\n')
+		b.WriteString(m.secretInput.View())
+
+		b.WriteRune('\n')
+		b.WriteRune('\n')
+
+		b.WriteString(m.spinner.View())
+		if m.quitting {
+			b.WriteString(" Stopping 'tsh login'...")
+		} else {
+			b.WriteString(" Verifying with 'tsh login'...")
+		}
+		b.WriteRune('\n')
+	}
+
 	if m.stage == "success" {
 		b.WriteString(blurredStyle.Render("Password:"))
 		b.WriteRune('\n')
@@ -286,7 +415,7 @@ func (m LoginModel) View() string {
 		b.WriteRune('\n')
 		b.WriteRune('\n')
 
-		b.WriteString(blurredStyle.Render("Secret (OTP secret or data:image/png;base64 QRcode string):"))
+		b.WriteString(blurredStyle.Render(secretLabel))
 		b.WriteRune('\n')
 		b.WriteString(m.secretInput.View())
 
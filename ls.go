@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Firebain/tssh/lists"
+)
+
+var lsColumns = []string{"hostname", "cluster", "labels", "last_used"}
+
+// lsRow is a server printed by 'tssh ls', with only the selected columns
+// set.
+type lsRow struct {
+	Hostname *string            `json:"hostname,omitempty"`
+	Cluster  *string            `json:"cluster,omitempty"`
+	Labels   *map[string]string `json:"labels,omitempty"`
+	LastUsed *time.Time         `json:"last_used,omitempty"`
+}
+
+// ListServers returns the cached servers query matches, best match first.
+// The servers are fetched when refresh is set or the cache is missing or
+// stale for the filter.
+func ListServers(p Profile, where string, query string, refresh bool) (*History, []lists.Server, error) {
+	filter, err := LoadNodeFilter(p, where)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := GetServersInfoFromCache(p)
+	if err != nil || info.Version < serversInfoVersion || info.Filter.String() != filter.String() {
+		refresh = true
+	}
+
+	// Before fetching, which drops the recents of an old cache.
+	history, err := LoadHistory(p, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if refresh {
+		info, err = fetchServersInfo(p, info, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rules, err := LoadLoginRules(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	favourites, err := LoadFavourites(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows := lists.Match(ServerRows(info, rules, history, favourites), query)
+
+	return history, rows, nil
+}
+
+// fetchServersInfo fetches and caches the servers without the TUI, keeping
+// the logins chosen in cached.
+func fetchServersInfo(p Profile, cached *ServersInfo, filter NodeFilter) (*ServersInfo, error) {
+	cr := p.Credentials()
+
+	expireAt, ok := cr.Expiry()
+	if !ok || expireAt.Before(time.Now()) {
+		return nil, fmt.Errorf("the certificate of %s has expired, run 'tssh' or 'tsh login'", p)
+	}
+
+	timeout, err := getFetchTimeout()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	info, err := FetchServersInfo(ctx, p, cr, filter, func(FetchProgress) {})
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("fetching servers timed out after %s", timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != nil {
+		info.DefaultLogin = cached.DefaultLogin
+		info.HostLogins = cached.HostLogins
+	}
+
+	return info, StoreServersInfo(p, info)
+}
+
+func parseColumns(value string) ([]string, error) {
+	columns := strings.Split(value, ",")
+
+	for _, column := range columns {
+		if !slices.Contains(lsColumns, column) {
+			return nil, fmt.Errorf("unknown column %q, use %s", column, strings.Join(lsColumns, ","))
+		}
+	}
+
+	return columns, nil
+}
+
+func lsRows(history *History, servers []lists.Server, columns []string) []lsRow {
+	rows := make([]lsRow, 0, len(servers))
+
+	for _, server := range servers {
+		row := lsRow{}
+
+		for _, column := range columns {
+			switch column {
+			case "hostname":
+				row.Hostname = &server.Hostname
+			case "cluster":
+				row.Cluster = &server.Cluster
+			case "labels":
+				labels := server.Labels
+				if labels == nil {
+					labels = map[string]string{}
+				}
+
+				row.Labels = &labels
+			case "last_used":
+				i := history.entry(Server{Hostname: server.Hostname, Cluster: server.Cluster})
+				if i >= 0 {
+					row.LastUsed = &history.Entries[i].LastUsed
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func (r lsRow) fields(columns []string) []string {
+	fields := make([]string, 0, len(columns))
+
+	for _, column := range columns {
+		switch column {
+		case "hostname":
+			fields = append(fields, *r.Hostname)
+		case "cluster":
+			fields = append(fields, *r.Cluster)
+		case "labels":
+			labels := []string{}
+			for key, value := range *r.Labels {
+				labels = append(labels, key+"="+value)
+			}
+			slices.Sort(labels)
+
+			fields = append(fields, strings.Join(labels, ","))
+		case "last_used":
+			if r.LastUsed == nil {
+				fields = append(fields, "")
+			} else {
+				fields = append(fields, r.LastUsed.Format(time.DateTime))
+			}
+		}
+	}
+
+	return fields
+}
+
+// PrintServers writes rows as an aligned table, JSON or tab separated lines.
+func PrintServers(format string, columns []string, rows []lsRow) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(rows)
+	case "plain":
+		for _, row := range rows {
+			fmt.Println(strings.Join(row.fields(columns), "\t"))
+		}
+
+		return nil
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.ToUpper(strings.Join(columns, "\t")))
+
+		for _, row := range rows {
+			fields := row.fields(columns)
+			for i, field := range fields {
+				if field == "" {
+					fields[i] = "-"
+				}
+			}
+
+			fmt.Fprintln(w, strings.Join(fields, "\t"))
+		}
+
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown format %q, use table, json or plain", format)
+}
+
+func setupLsCommand(fs *flag.FlagSet, g *Globals) func(args []string) error {
+	format := fs.String("format", "table", "table, json or plain")
+	columnList := fs.String("columns", strings.Join(lsColumns, ","), "the columns to print")
+	refresh := fs.Bool("refresh", false, "fetch the servers before printing them")
+
+	return func(args []string) error {
+		if !slices.Contains([]string{"table", "json", "plain"}, *format) {
+			return usagef("unknown format %q, use table, json or plain", *format)
+		}
+
+		columns, err := parseColumns(*columnList)
+		if err != nil {
+			return usageError{err.Error()}
+		}
+
+		profile, err := g.Profile()
+		if err != nil {
+			return err
+		}
+
+		history, servers, err := ListServers(profile, g.Where, strings.Join(args, " "), *refresh)
+		if err != nil {
+			return err
+		}
+
+		return PrintServers(*format, columns, lsRows(history, servers, columns))
+	}
+}
@@ -1,17 +1,16 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	_ "image/png"
-	"io"
+	"log"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/Firebain/tssh/lists"
-	"github.com/creack/pty"
-	"github.com/pquerna/otp/totp"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
@@ -19,8 +18,11 @@ import (
 	"github.com/gravitational/teleport/api/client"
 )
 
+// LoginSuccess ends RunLoginCmd, attempts is how many times the stored auth
+// was tried and 0 after an interactive login.
 type LoginSuccess struct {
-	cr client.Credentials
+	profile  Profile
+	attempts int
 }
 
 type CacheEmptyMsg struct{}
@@ -30,78 +32,121 @@ type CacheLoadedMsg struct {
 }
 
 type ServersLoadedMsg struct {
+	profile Profile
 	servers *ServersInfo
 }
 
-type UserSelectedMsg struct{}
-
-func RunLoginCmd() tea.Cmd {
-	auth, err := GetAuth()
-	if err != nil {
-		return ErrorMsg(err)
-	}
+// ServersRefreshedMsg and RefreshFailedMsg end a background refresh, the
+// cached servers stay on screen meanwhile.
+type ServersRefreshedMsg struct {
+	profile Profile
+	servers *ServersInfo
+}
 
-	// TODO: More fancy view for this
-	fmt.Println("Running 'tsh login'")
+type RefreshFailedMsg struct {
+	profile Profile
+	err     error
+}
 
-	c := exec.Command("tsh", "login")
+type FetchProgressMsg struct {
+	fetched  FetchProgress
+	progress chan FetchProgress
+}
 
-	if auth == nil {
-		return tea.ExecProcess(c, func(err error) tea.Msg {
-			if err != nil {
-				return errorMsg{err}
-			}
+type UserSelectedMsg struct{}
 
-			cr := client.LoadProfile("", "")
+type SSHExitedMsg struct {
+	code int
+}
 
-			return LoginSuccess{cr}
-		})
-	} else {
-		f, err := pty.Start(c)
+// RunLoginCmd logs in to p with the stored auth, or hands the terminal to an
+// interactive 'tsh login' when there is none. Logging in may wait for the next
+// OTP period, so it all runs in the command and never blocks the UI.
+func RunLoginCmd(p Profile) tea.Cmd {
+	login := func() tea.Msg {
+		auth, err := GetAuth(p)
 		if err != nil {
-			return ErrorMsg(err)
+			return errorMsg{err}
 		}
-		defer f.Close()
 
-		scanner := bufio.NewScanner(f)
+		log.Printf("running tsh login for %s", p)
 
-		s := scanner.Scan()
-		if !s {
-			return ErrorMsg(scanner.Err())
-		}
+		if auth == nil {
+			c := exec.Command(tshBinary, append([]string{"login"}, p.TshArgs()...)...)
 
-		_, err = io.WriteString(f, auth.Password+"\n")
-		if err != nil {
-			return ErrorMsg(err)
-		}
+			return tea.ExecProcess(c, func(err error) tea.Msg {
+				if err != nil {
+					return errorMsg{err}
+				}
 
-		s = scanner.Scan()
-		if !s {
-			return ErrorMsg(scanner.Err())
+				return LoginSuccess{profile: reloadProfile(p)}
+			})()
 		}
 
-		code, err := totp.GenerateCode(auth.Secret, time.Now())
+		attempts, err := TshLogin(context.Background(), p, auth)
 		if err != nil {
-			return ErrorMsg(err)
+			return errorMsg{err}
 		}
 
-		_, err = io.WriteString(f, code+"\n")
-		if err != nil {
-			return ErrorMsg(err)
-		}
+		return LoginSuccess{profile: reloadProfile(p), attempts: attempts}
+	}
 
-		io.Copy(io.Discard, f)
+	// TODO: More fancy view for this
+	return tea.Sequence(
+		tea.Println(fmt.Sprintf("Running 'tsh login' for %s", p)),
+		login,
+	)
+}
 
-		cr := client.LoadProfile("", "")
+// reloadProfile picks up the user tsh has just logged in as, and moves the
+// state kept before the user was known to it.
+func reloadProfile(p Profile) Profile {
+	reloaded, err := LoadProfile(p.Name)
+	if err != nil {
+		return p
+	}
 
-		return func() tea.Msg { return LoginSuccess{cr} }
+	err = MigrateProfileState(reloaded)
+	if err != nil {
+		log.Printf("moving the state of %s to %s: %v", p.Name, reloaded, err)
 	}
+
+	return reloaded
 }
 
-func RunConnectCmd(user string, hostname string) tea.Cmd {
-	c := exec.Command("tsh", "ssh", user+"@"+hostname)
+func LoadCacheCmd(p Profile) tea.Cmd {
+	return func() tea.Msg {
+		servers, err := GetServersInfoFromCache(p)
+		if err != nil {
+			return CacheEmptyMsg{}
+		}
+
+		return CacheLoadedMsg{servers}
+	}
+}
+
+// RunConnectCmd runs tsh ssh with flags before the target and the remote
+// command after it.
+func RunConnectCmd(p Profile, user string, hostname string, cluster string, flags []string, command []string) tea.Cmd {
+	args := append([]string{"ssh"}, p.TshArgs()...)
+	if cluster != "" {
+		args = append(args, "--cluster="+cluster)
+	}
+
+	args = append(args, flags...)
+	args = append(args, user+"@"+hostname)
+	args = append(args, command...)
+	log.Printf("running tsh %s", strings.Join(args, " "))
+
+	c := exec.Command(tshBinary, args...)
 
 	return tea.ExecProcess(c, func(err error) tea.Msg {
+		// tsh has printed why, only its exit code is kept.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return SSHExitedMsg{exitErr.ExitCode()}
+		}
+
 		if err != nil {
 			return errorMsg{err}
 		}
@@ -111,37 +156,94 @@ func RunConnectCmd(user string, hostname string) tea.Cmd {
 }
 
 type AppModel struct {
-	cr   client.Credentials
-	info *ServersInfo
+	profile Profile
+	cr      client.Credentials
+	info    *ServersInfo
+	where   string
+
+	// query is given on the command line, it connects straight away when it
+	// names one server. refetched tells it already missed a fresh cache.
+	query     string
+	refetched bool
+	failed    bool
+
+	// queried is the server the query named, it is connected to once the
+	// default user is picked.
+	queried *lists.Server
+
+	// args are given on the command line after "--", they are passed to tsh
+	// ssh unless others are typed in the search box. exitStatus is the exit
+	// code of tsh ssh.
+	args       []string
+	exitStatus int
+
+	refreshing bool
+	refreshErr error
+
+	loginRules   []LoginRule
+	sshArgsRules []SSHArgsRule
+	history      *History
+	favourites   *Favourites
+
+	// pending is the server waiting for a login its roles allow, with the
+	// tsh ssh arguments to connect with, choosing the one a login is being
+	// picked for with ctrl+l.
+	pending     *Server
+	pendingArgs []string
+	choosing    *Server
+	warning     string
+
+	cancelFetch context.CancelFunc
+	progress    chan FetchProgress
+	fetched     FetchProgress
+
+	profiles []Profile
 
 	panel string
 
-	spinner     spinner.Model
-	serversList lists.ServersListModel
-	usersList   lists.UsersListModel
+	spinner      spinner.Model
+	serversList  lists.ServersListModel
+	usersList    lists.UsersListModel
+	profilesList lists.ProfilesListModel
 }
 
-func InitAppModel() AppModel {
-	cr := client.LoadProfile("", "")
+func InitAppModel(profile Profile, where string, query string, args []string) AppModel {
+	cr := profile.Credentials()
 
 	s := spinner.New()
 	s.Spinner = spinner.Line
 	s.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("69"))
 
 	return AppModel{
-		cr: cr,
+		profile: profile,
+		cr:      cr,
+		where:   where,
+		query:   query,
+		args:    args,
 
 		panel: "empty",
 
-		spinner:     s,
-		serversList: lists.InitServersListModel(),
-		usersList:   lists.InitUsersListModel(),
+		spinner:      s,
+		serversList:  lists.InitServersListModel(),
+		usersList:    lists.InitUsersListModel(),
+		profilesList: lists.InitProfilesListModel(),
 	}
 }
 
 func (m AppModel) Init() tea.Cmd {
+	return m.openProfile()
+}
+
+// openProfile logs in to m.profile when its certificate has expired and
+// shows its cached servers.
+func (m AppModel) openProfile() tea.Cmd {
 	expireAt, canDetectExpire := m.cr.Expiry()
 	if !canDetectExpire {
+		// A proxy given with --proxy may have no profile yet.
+		if m.profile.Proxy != "" && m.profile.User == "" {
+			return RunLoginCmd(m.profile)
+		}
+
 		return tea.Sequence(
 			tea.Println("Can't detect profile. Please run 'tsh login'"),
 			tea.Quit,
@@ -149,15 +251,106 @@ func (m AppModel) Init() tea.Cmd {
 	}
 
 	if expireAt.Before(time.Now()) {
-		return RunLoginCmd()
+		return RunLoginCmd(m.profile)
+	}
+
+	return LoadCacheCmd(m.profile)
+}
+
+// fetchServers fetches the servers of the profile, keeping the selected login
+// and the recently used servers. The fetch can be stopped with m.cancelFetch
+// and reports its progress with FetchProgressMsg. A background fetch ends
+// with ServersRefreshedMsg or RefreshFailedMsg instead of ServersLoadedMsg or
+// an error.
+func (m AppModel) fetchServers(background bool) (AppModel, tea.Cmd) {
+	filter, err := LoadNodeFilter(m.profile, m.where)
+	if err != nil {
+		return m, ErrorMsg(err)
 	}
 
-	servers, err := GetServersInfoFromCache()
+	timeout, err := getFetchTimeout()
 	if err != nil {
-		return func() tea.Msg { return CacheEmptyMsg{} }
+		return m, ErrorMsg(err)
 	}
 
-	return func() tea.Msg { return CacheLoadedMsg{servers} }
+	if m.cancelFetch != nil {
+		m.cancelFetch()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	progress := make(chan FetchProgress, 1)
+
+	m.cancelFetch = cancel
+	m.progress = progress
+	m.fetched = FetchProgress{}
+
+	profile, cr, info := m.profile, m.cr, m.info
+
+	fetch := func() tea.Msg {
+		defer cancel()
+		defer close(progress)
+
+		log.Printf("fetching servers of %s, filter %q", profile, filter)
+		start := time.Now()
+
+		fetched, err := FetchServersInfo(ctx, profile, cr, filter, func(p FetchProgress) {
+			// Progress is dropped while the previous one is not shown yet.
+			select {
+			case progress <- p:
+			default:
+			}
+		})
+
+		log.Printf("fetched servers in %s, error: %v", time.Since(start).Round(time.Millisecond), err)
+
+		if err != nil {
+			switch ctx.Err() {
+			case context.Canceled:
+				return nil
+			case context.DeadlineExceeded:
+				err = fmt.Errorf("fetching servers timed out after %s", timeout)
+			}
+
+			if background {
+				return RefreshFailedMsg{profile, err}
+			}
+
+			return errorMsg{err}
+		}
+
+		if info != nil {
+			fetched.DefaultLogin = info.DefaultLogin
+			fetched.HostLogins = info.HostLogins
+		}
+
+		if background {
+			return ServersRefreshedMsg{profile, fetched}
+		}
+
+		return ServersLoadedMsg{profile, fetched}
+	}
+
+	if !background {
+		m.panel = "spiner"
+
+		return m, tea.Batch(m.spinner.Tick, fetch, waitForProgress(progress))
+	}
+
+	m.refreshing = true
+	m.refreshErr = nil
+
+	return m, tea.Batch(fetch, waitForProgress(progress))
+}
+
+func waitForProgress(progress chan FetchProgress) tea.Cmd {
+	return func() tea.Msg {
+		fetched, ok := <-progress
+		if !ok {
+			return nil
+		}
+
+		return FetchProgressMsg{fetched, progress}
+	}
 }
 
 func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -165,86 +358,250 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c", "esc":
+			if m.cancelFetch != nil {
+				m.cancelFetch()
+			}
+
+			// Cancelling a fetch goes back to the servers cached before.
+			if msg.String() == "esc" && m.panel == "spiner" && m.info != nil {
+				m.panel = "list"
+				if m.info.DefaultLogin == "" {
+					m.panel = "user"
+				}
+
+				return m, nil
+			}
+
 			return m, tea.Quit
 		case "ctrl+r":
-			m.panel = "spiner"
+			if m.refreshing || m.panel == "spiner" {
+				return m, nil
+			}
 
-			return m, tea.Batch(
-				m.spinner.Tick,
-				func() tea.Msg {
-					info, err := FetchServersInfo(m.cr)
-					if err != nil {
-						return errorMsg{err}
-					}
+			return m.fetchServers(m.info != nil)
+		case "ctrl+u":
+			if m.pending != nil || m.choosing != nil {
+				m.pending = nil
+				m.choosing = nil
+				m.usersList = m.usersList.SetUsers(m.info.Logins)
+			}
 
-					info.DefaultLogin = m.info.DefaultLogin
+			m.panel = "user"
+
+			return m, nil
+		case "ctrl+l":
+			if m.panel != "list" {
+				break
+			}
+
+			row, ok := m.serversList.Highlighted()
+			if !ok {
+				return m, nil
+			}
+
+			server, ok := m.info.FindServer(row.UUID, row.Hostname, row.Cluster)
+			if !ok {
+				return m, nil
+			}
+
+			m.choosing = &server
+			m.pending = nil
+
+			if server.Logins != nil {
+				m.usersList = m.usersList.SetUsers(server.Logins)
+			} else {
+				m.usersList = m.usersList.SetUsers(m.info.Logins)
+			}
 
-					return ServersLoadedMsg{info}
-				},
-			)
-		case "ctrl+u":
 			m.panel = "user"
 
+			return m, nil
+		case "ctrl+o":
+			if m.panel != "list" {
+				break
+			}
+
+			return m.rerun()
+		case "ctrl+p":
+			profiles, err := ListProfiles()
+			if err != nil {
+				return m, ErrorMsg(err)
+			}
+
+			names := make([]string, 0, len(profiles))
+			for _, p := range profiles {
+				names = append(names, p.String())
+			}
+
+			m.profiles = profiles
+			m.profilesList = m.profilesList.SetProfiles(names, m.profile.String())
+			m.panel = "profile"
+
 			return m, nil
 		}
+	case SSHExitedMsg:
+		m.panel = "empty"
+		m.failed = true
+		m.exitStatus = msg.code
+
+		return m, tea.Quit
 	case errorMsg:
 		m.panel = "empty"
+		m.failed = true
 
 		return m, tea.Sequence(
 			tea.Println(msg.err),
 			tea.Quit,
 		)
 	case LoginSuccess:
-		m.cr = msg.cr
+		m.profile = msg.profile
+		m.cr = msg.profile.Credentials()
+
+		switch {
+		case msg.attempts == 1:
+			return m, tea.Sequence(tea.Println("Logged in"), LoadCacheCmd(m.profile))
+		case msg.attempts > 1:
+			return m, tea.Sequence(tea.Println(fmt.Sprintf("Logged in after %d attempts", msg.attempts)), LoadCacheCmd(m.profile))
+		}
 
-		return m, func() tea.Msg {
-			servers, err := GetServersInfoFromCache()
-			if err != nil {
-				return CacheEmptyMsg{}
+		return m, LoadCacheCmd(m.profile)
+	case lists.ProfileSelectedMsg:
+		for _, p := range m.profiles {
+			if p.String() == msg.Profile {
+				m.profile = p
+				m.cr = p.Credentials()
 			}
+		}
 
-			return CacheLoadedMsg{servers}
+		if m.cancelFetch != nil {
+			m.cancelFetch()
 		}
-	case CacheEmptyMsg:
-		m.panel = "spiner"
 
-		return m, tea.Batch(
-			m.spinner.Tick,
-			func() tea.Msg {
-				info, err := FetchServersInfo(m.cr)
-				if err != nil {
-					return errorMsg{err}
-				}
+		m.panel = "empty"
+		m.refreshing = false
+		m.refreshErr = nil
 
-				return ServersLoadedMsg{info}
-			},
-		)
+		return m, m.openProfile()
+	case CacheEmptyMsg:
+		m.info = nil
+
+		return m.fetchServers(false)
 	case CacheLoadedMsg:
 		m.info = msg.servers
 
-		m.serversList = m.serversList.SetServers(m.info.Servers, m.info.RecentlyUsedServers)
+		var err error
+
+		m, err = m.loadProfileState()
+		if err != nil {
+			return m, ErrorMsg(err)
+		}
+
+		m.serversList = m.serversList.SetServers(m.serverRows())
 		m.usersList = m.usersList.SetUsers(msg.servers.Logins)
 
+		filter, err := LoadNodeFilter(m.profile, m.where)
+		if err != nil {
+			return m, ErrorMsg(err)
+		}
+
+		// A cache fetched with another filter has the wrong servers.
+		if m.info.Version < serversInfoVersion || m.info.Filter.String() != filter.String() {
+			return m.fetchServers(false)
+		}
+
+		if m.query != "" {
+			return m.runQuery()
+		}
+
+		var cmd tea.Cmd
+
+		ttl, err := getCacheTTL()
+		if err != nil {
+			return m, ErrorMsg(err)
+		}
+
+		if time.Since(m.info.FetchedAt) >= ttl {
+			m, cmd = m.fetchServers(true)
+		}
+
 		if msg.servers.DefaultLogin == "" {
 			m.panel = "user"
 
-			return m, nil
+			return m, cmd
 		}
 
 		m.panel = "list"
 
+		return m, cmd
+	case ServersRefreshedMsg:
+		// Another profile was opened while fetching.
+		if msg.profile != m.profile {
+			return m, nil
+		}
+
+		m.refreshing = false
+
+		// The logins may have changed while fetching.
+		msg.servers.DefaultLogin = m.info.DefaultLogin
+		msg.servers.HostLogins = m.info.HostLogins
+
+		err := StoreServersInfo(m.profile, msg.servers)
+		if err != nil {
+			return m, ErrorMsg(err)
+		}
+
+		m.info = msg.servers
+
+		m.serversList = m.serversList.RefreshServers(m.serverRows())
+		if m.pending == nil && m.choosing == nil {
+			m.usersList = m.usersList.SetUsers(m.info.Logins)
+		}
+
+		return m, nil
+	case FetchProgressMsg:
+		if msg.progress != m.progress {
+			return m, nil
+		}
+
+		m.fetched = msg.fetched
+
+		return m, waitForProgress(msg.progress)
+	case RefreshFailedMsg:
+		if msg.profile != m.profile {
+			return m, nil
+		}
+
+		m.refreshing = false
+		m.refreshErr = msg.err
+
 		return m, nil
 	case ServersLoadedMsg:
-		err := StoreServersInfo(msg.servers)
+		// Another profile was opened while fetching.
+		if msg.profile != m.profile {
+			return m, nil
+		}
+
+		err := StoreServersInfo(m.profile, msg.servers)
 		if err != nil {
 			return m, ErrorMsg(err)
 		}
 
 		m.info = msg.servers
 
-		m.serversList = m.serversList.SetServers(msg.servers.Servers, m.info.RecentlyUsedServers)
+		m, err = m.loadProfileState()
+		if err != nil {
+			return m, ErrorMsg(err)
+		}
+
+		m.serversList = m.serversList.SetServers(m.serverRows())
 		m.usersList = m.usersList.SetUsers(msg.servers.Logins)
 
+		if m.query != "" {
+			m.refetched = true
+
+			return m.runQuery()
+		}
+
 		if msg.servers.DefaultLogin == "" {
 			m.panel = "user"
 
@@ -255,28 +612,83 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		return m, nil
 	case lists.UserSelectedMsg:
-		m.info.DefaultLogin = msg.User
+		if m.pending != nil {
+			server := *m.pending
+			m.pending = nil
+			m.usersList = m.usersList.SetUsers(m.info.Logins)
 
-		err := StoreServersInfo(m.info)
+			return m.connect(server, msg.User, m.pendingArgs)
+		}
+
+		if m.choosing != nil {
+			server := *m.choosing
+			m.choosing = nil
+			m.usersList = m.usersList.SetUsers(m.info.Logins)
+
+			// Picking the login the server would get anyway forgets the choice.
+			delete(m.info.HostLogins, server.Key())
+			if msg.User != m.info.LoginFor(server, m.loginRules) {
+				if m.info.HostLogins == nil {
+					m.info.HostLogins = map[string]string{}
+				}
+
+				m.info.HostLogins[server.Key()] = msg.User
+			}
+		} else {
+			m.info.DefaultLogin = msg.User
+		}
+
+		err := StoreServersInfo(m.profile, m.info)
 		if err != nil {
 			return m, ErrorMsg(err)
 		}
 
+		m.serversList = m.serversList.RefreshServers(m.serverRows())
 		m.panel = "list"
 
+		if m.queried != nil {
+			server := *m.queried
+			m.queried = nil
+			m.panel = "empty"
+
+			return m, selectServer(server)
+		}
+
 		return m, nil
 	case lists.ServerSelectedMsg:
-		for i := len(m.info.RecentlyUsedServers) - 1; i > 0; i-- {
-			m.info.RecentlyUsedServers[i] = m.info.RecentlyUsedServers[i-1]
+		server, ok := m.info.FindServer(msg.UUID, msg.Hostname, msg.Cluster)
+		if !ok {
+			server = Server{UUID: msg.UUID, Hostname: msg.Hostname, Cluster: msg.Cluster}
 		}
-		m.info.RecentlyUsedServers[0] = msg.Hostname
 
-		err := StoreServersInfo(m.info)
-		if err != nil {
-			return m, ErrorMsg(err)
+		m.warning = ""
+		login := m.info.LoginFor(server, m.loginRules)
+
+		args := msg.Args
+		if len(args) == 0 {
+			args = m.args
+		}
+
+		if !server.AllowsLogin(login) {
+			if len(server.Logins) == 0 {
+				m.warning = fmt.Sprintf("Your roles allow no logins on %s", server.Hostname)
+				m.serversList = m.serversList.SetServers(m.serverRows())
+				m.panel = "list"
+
+				return m, nil
+			}
+
+			// Pick one of the allowed logins for this connection only.
+			m.warning = fmt.Sprintf("%s is not allowed on %s", login, server.Hostname)
+			m.pending = &server
+			m.pendingArgs = args
+			m.usersList = m.usersList.SetUsers(server.Logins)
+			m.panel = "user"
+
+			return m, nil
 		}
 
-		return m, RunConnectCmd(m.info.DefaultLogin, msg.Hostname)
+		return m.connect(server, login, args)
 	}
 
 	var cmd tea.Cmd
@@ -299,6 +711,12 @@ func (m AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, cmd
 	}
 
+	if m.panel == "profile" {
+		m.profilesList, cmd = m.profilesList.Update(msg)
+
+		return m, cmd
+	}
+
 	return m, nil
 }
 
@@ -308,7 +726,19 @@ func (m AppModel) View() string {
 	}
 
 	if m.panel == "spiner" {
-		return fmt.Sprintf("%s Loading servers...\n", m.spinner.View())
+		if m.fetched.Pages == 0 {
+			return fmt.Sprintf("%s Loading servers...\n", m.spinner.View())
+		}
+
+		return fmt.Sprintf("%s Loading servers... %s\n", m.spinner.View(), blurredStyle.Render(m.fetched.String()))
+	}
+
+	if m.panel == "user" && m.choosing != nil {
+		return fmt.Sprintf("Select user for %s:\n\n%s\n", m.choosing.Hostname, m.usersList.View())
+	}
+
+	if m.panel == "user" && m.pending != nil {
+		return fmt.Sprintf("%s\n\nSelect user for %s:\n\n%s\n", errorStyle.Render(m.warning), m.pending.Hostname, m.usersList.View())
 	}
 
 	if m.panel == "user" {
@@ -316,97 +746,240 @@ func (m AppModel) View() string {
 	}
 
 	if m.panel == "list" {
+		lines := []string{}
+
+		if header := m.header(); header != "" {
+			lines = append(lines, header)
+		}
+
+		if m.warning != "" {
+			lines = append(lines, errorStyle.Render(m.warning))
+		}
+
+		if len(lines) != 0 {
+			return strings.Join(lines, "\n") + "\n\n" + m.serversList.View()
+		}
+
 		return m.serversList.View()
 	}
 
+	if m.panel == "profile" {
+		return fmt.Sprintf("Select profile:\n\n%s\n", m.profilesList.View())
+	}
+
 	return ""
 }
 
-func main() {
-	if len(os.Args) == 2 && os.Args[1] == "login" {
-		auth, err := GetAuth()
-		if err != nil {
-			fmt.Println("Error running program:", err)
-			os.Exit(1)
-		}
+// runQuery connects to the server the query names, or leaves the query in the
+// search box when it matches several servers. A query matching nothing
+// fetches the servers again once before failing.
+func (m AppModel) runQuery() (AppModel, tea.Cmd) {
+	m.serversList = m.serversList.SetQuery(m.query)
 
-		if auth != nil {
-			fmt.Println("Auth information found in keychain. Re-creating...")
+	server, matches := m.serversList.DirectMatch()
+	if matches == 0 && !m.refetched {
+		return m.fetchServers(false)
+	}
 
-			err = DeleteAuth()
+	query := m.query
+	m.query = ""
 
-			if err != nil {
-				fmt.Println("Error running program:", err)
-				os.Exit(1)
-			}
+	if matches == 0 {
+		m.panel = "empty"
+		m.failed = true
 
-			err = StoreAuth(*auth)
+		return m, tea.Sequence(
+			tea.Println(fmt.Sprintf("No servers match %q", query)),
+			tea.Quit,
+		)
+	}
 
-			if err != nil {
-				fmt.Println("Error running program:", err)
-				os.Exit(1)
-			}
+	m.panel = "list"
+	if m.info.DefaultLogin == "" {
+		m.panel = "user"
+	}
 
-			fmt.Println("Success")
+	if matches > 1 {
+		return m, nil
+	}
 
-			os.Exit(1)
-		}
+	// Without a login the default user is picked first.
+	if server.Login == "" {
+		m.queried = &server
 
-		m := InitLoginModel()
-		p := tea.NewProgram(m)
-		_, err = p.Run()
-		if err != nil {
-			fmt.Println("Error running program:", err)
-			os.Exit(1)
-		}
+		return m, nil
+	}
 
-		return
+	m.panel = "empty"
+
+	return m, selectServer(server)
+}
+
+func selectServer(server lists.Server) tea.Cmd {
+	return func() tea.Msg {
+		return lists.ServerSelectedMsg{UUID: server.UUID, Hostname: server.Hostname, Cluster: server.Cluster}
 	}
+}
 
-	if len(os.Args) == 2 && os.Args[1] == "logout" {
-		err := DeleteAuth()
+// serverRows shows every server with the login it will be connected with.
+func (m AppModel) serverRows() []lists.Server {
+	return ServerRows(m.info, m.loginRules, m.history, m.favourites)
+}
 
-		if err != nil {
-			fmt.Println("Error running program:", err)
-			os.Exit(1)
-		}
+// ServerRows turns the cached servers into rows of the servers list, ranked
+// by the history and the favourites.
+func ServerRows(info *ServersInfo, rules []LoginRule, history *History, favourites *Favourites) []lists.Server {
+	now := time.Now()
+
+	rows := make([]lists.Server, 0, len(info.Servers))
+	for _, server := range info.Servers {
+		favourite, isFavourite := favourites.Find(server)
+
+		rows = append(rows, lists.Server{
+			UUID:      server.UUID,
+			Hostname:  server.Hostname,
+			Cluster:   server.Cluster,
+			Labels:    server.Labels,
+			Login:     info.LoginFor(server, rules),
+			Frecency:  history.Frecency(server, now),
+			Favourite: isFavourite,
+			Alias:     favourite.Alias,
+		})
+	}
 
-		fmt.Println("Deleted")
+	return rows
+}
 
-		return
+// loadProfileState reads the login and tsh ssh arguments rules, the
+// connection history and the favourites of the profile, which are kept outside the servers cache.
+func (m AppModel) loadProfileState() (AppModel, error) {
+	rules, err := LoadLoginRules(m.profile)
+	if err != nil {
+		return m, err
 	}
 
-	if len(os.Args) == 3 && os.Args[1] == "cache" && os.Args[2] == "prune" {
-		err := DeleteServersInto()
+	history, err := LoadHistory(m.profile, m.info)
+	if err != nil {
+		return m, err
+	}
 
-		if err != nil {
-			fmt.Println("Error running program:", err)
-			os.Exit(1)
-		}
+	favourites, err := LoadFavourites(m.profile)
+	if err != nil {
+		return m, err
+	}
 
-		fmt.Println("Deleted")
+	sshArgsRules, err := LoadSSHArgsRules(m.profile)
+	if err != nil {
+		return m, err
+	}
 
-		return
+	m.loginRules = rules
+	m.sshArgsRules = sshArgsRules
+	m.history = history
+	m.favourites = favourites
+
+	return m, nil
+}
+
+// connect records the connection in the history and connects to server as
+// login, with the tsh ssh arguments configured for server and args.
+func (m AppModel) connect(server Server, login string, args []string) (tea.Model, tea.Cmd) {
+	m.history.Record(server, login, args, time.Now())
+
+	err := StoreHistory(m.profile, m.history)
+	if err != nil {
+		return m, ErrorMsg(err)
 	}
 
-	if len(os.Args) == 3 && os.Args[1] == "cache" && os.Args[2] == "location" {
-		location, err := GetCachePath()
+	m.panel = "empty"
 
-		if err != nil {
-			fmt.Println("Error running program:", err)
-			os.Exit(1)
-		}
+	flags, command := mergeSSHArgs(SSHArgsFor(server, m.sshArgsRules), args)
+
+	return m, RunConnectCmd(m.profile, login, m.info.ConnectTarget(server), server.Cluster, flags, command)
+}
 
-		fmt.Println(location)
+// rerun connects to the highlighted server the way it was last connected to,
+// or repeats the last connection when nothing is highlighted.
+func (m AppModel) rerun() (tea.Model, tea.Cmd) {
+	var entry HistoryEntry
 
-		return
+	row, ok := m.serversList.Highlighted()
+	if ok {
+		entry, ok = m.history.Last(Server{Hostname: row.Hostname, Cluster: row.Cluster})
+	} else if sorted := m.history.Sorted(); len(sorted) != 0 {
+		entry, ok = sorted[0], true
+	}
+
+	if !ok {
+		return m, nil
 	}
 
-	m := InitAppModel()
-	p := tea.NewProgram(m)
-	_, err := p.Run()
+	// Entries moved from the recently used servers may miss the cluster.
+	name := entry.Hostname
+	if entry.Cluster != "" {
+		name = entry.Cluster + "/" + entry.Hostname
+	}
+
+	server, err := ResolveServer(m.info, name)
 	if err != nil {
-		fmt.Println("Error running program:", err)
-		os.Exit(1)
+		m.warning = err.Error()
+
+		return m, nil
+	}
+
+	return m.connect(server, entry.User, entry.Args)
+}
+
+// header shows the active filter, how old the cache is and whether it is
+// being refreshed.
+func (m AppModel) header() string {
+	parts := []string{}
+
+	if !m.info.Filter.IsEmpty() {
+		parts = append(parts, "Filter: "+m.info.Filter.String())
 	}
+
+	if !m.info.FetchedAt.IsZero() {
+		parts = append(parts, "Updated "+formatAge(time.Since(m.info.FetchedAt)))
+	}
+
+	if m.refreshing && m.fetched.Pages != 0 {
+		parts = append(parts, "refreshing... "+m.fetched.String())
+	} else if m.refreshing {
+		parts = append(parts, "refreshing...")
+	} else if m.refreshErr != nil {
+		parts = append(parts, "refresh failed: "+m.refreshErr.Error())
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return blurredStyle.Render(strings.Join(parts, " · "))
+}
+
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+}
+
+func main() {
+	root := RootCommand()
+
+	// Completing gets the words as typed, flags included.
+	if len(os.Args) >= 2 && os.Args[1] == completeCommand {
+		Complete(os.Stdout, root, os.Args[2:])
+
+		return
+	}
+
+	os.Exit(Execute(root, os.Args[1:]))
 }
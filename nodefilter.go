@@ -0,0 +1,61 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// NodeFilter is passed to Teleport when listing nodes, so only the matching
+// ones are fetched and cached. Where is a Teleport predicate expression such
+// as `labels.env == "prod"`.
+type NodeFilter struct {
+	Where          string            `json:"where,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	SearchKeywords []string          `json:"search_keywords,omitempty"`
+}
+
+// LoadNodeFilter returns the filter configured for p, where replaces its
+// predicate expression when not empty.
+func LoadNodeFilter(p Profile, where string) (NodeFilter, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return NodeFilter{}, err
+	}
+
+	filter := config.Profile(p.Name).Filter
+	if where != "" {
+		filter.Where = where
+	}
+
+	return filter, nil
+}
+
+func (f NodeFilter) IsEmpty() bool {
+	return f.String() == ""
+}
+
+// String describes the filter the same way for equal filters, it is also
+// used to tell whether the cache was fetched with the active filter.
+func (f NodeFilter) String() string {
+	parts := []string{}
+
+	if f.Where != "" {
+		parts = append(parts, "where "+f.Where)
+	}
+
+	if len(f.Labels) != 0 {
+		labels := []string{}
+		for _, key := range slices.Sorted(maps.Keys(f.Labels)) {
+			labels = append(labels, key+"="+f.Labels[key])
+		}
+
+		parts = append(parts, "labels "+strings.Join(labels, ","))
+	}
+
+	if len(f.SearchKeywords) != 0 {
+		parts = append(parts, "search "+strings.Join(f.SearchKeywords, " "))
+	}
+
+	return strings.Join(parts, ", ")
+}
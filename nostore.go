@@ -0,0 +1,34 @@
+//go:build !darwin && !linux
+
+package main
+
+// There is no supported OS keyring on other platforms, so Auth always lives
+// in the encrypted file.
+func NewSystemAuthStore(account string) AuthStore {
+	store, err := NewFileStore(account)
+	if err != nil {
+		return errorStore{err}
+	}
+
+	return store
+}
+
+type errorStore struct {
+	err error
+}
+
+func (s errorStore) Name() string {
+	return "none"
+}
+
+func (s errorStore) Store(auth Auth) error {
+	return s.err
+}
+
+func (s errorStore) Get() (*Auth, error) {
+	return nil, s.err
+}
+
+func (s errorStore) Delete() error {
+	return s.err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// ParseOTPSecret accepts everything the secret field of 'tssh login' does: a
+// base32 secret, an otpauth:// URI, a data:image/png;base64 string, a path to
+// a PNG or JPEG image of the QR code, or "clipboard" for an image copied to
+// the clipboard.
+func ParseOTPSecret(input string) (Auth, error) {
+	input = strings.TrimSpace(input)
+
+	switch {
+	case input == "":
+		return Auth{}, errors.New("secret is empty")
+	case strings.HasPrefix(input, "otpauth://"):
+		return ParseOTPAuthURL(input)
+	case strings.HasPrefix(input, "data:image/png;base64,"):
+		imageRaw, err := base64.StdEncoding.DecodeString(input[22:])
+		if err != nil {
+			return Auth{}, err
+		}
+
+		return parseQRImage(imageRaw)
+	case input == "clipboard":
+		imageRaw, err := readClipboardImage()
+		if err != nil {
+			return Auth{}, err
+		}
+
+		return parseQRImage(imageRaw)
+	}
+
+	path := input
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		imageRaw, err := os.ReadFile(path)
+		if err != nil {
+			return Auth{}, err
+		}
+
+		return parseQRImage(imageRaw)
+	}
+
+	secret := strings.ToUpper(strings.ReplaceAll(input, " ", ""))
+
+	_, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return Auth{}, errors.New("not a base32 secret, otpauth:// URI or QR code image")
+	}
+
+	return Auth{Secret: secret}, nil
+}
+
+func parseQRImage(imageRaw []byte) (Auth, error) {
+	image, _, err := image.Decode(bytes.NewReader(imageRaw))
+	if err != nil {
+		return Auth{}, err
+	}
+
+	bmp, err := gozxing.NewBinaryBitmapFromImage(image)
+	if err != nil {
+		return Auth{}, err
+	}
+
+	qrReader := qrcode.NewQRCodeReader()
+	result, err := qrReader.Decode(bmp, nil)
+	if err != nil {
+		return Auth{}, fmt.Errorf("no QR code found in image: %w", err)
+	}
+
+	return ParseOTPAuthURL(result.GetText())
+}
+
+// readClipboardImage asks the platform clipboard tool for a PNG image.
+func readClipboardImage() ([]byte, error) {
+	var candidates [][]string
+
+	if runtime.GOOS == "darwin" {
+		candidates = [][]string{{"pngpaste", "-"}}
+	} else {
+		candidates = [][]string{
+			{"wl-paste", "--type", "image/png"},
+			{"xclip", "-selection", "clipboard", "-target", "image/png", "-out"},
+		}
+	}
+
+	for _, args := range candidates {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+
+		out, err := exec.Command(args[0], args[1:]...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("no image in clipboard: %w", err)
+		}
+
+		return out, nil
+	}
+
+	tools := make([]string, 0, len(candidates))
+	for _, args := range candidates {
+		tools = append(tools, args[0])
+	}
+
+	return nil, fmt.Errorf("reading images from clipboard requires %s", strings.Join(tools, " or "))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+const testOTPAuthURL = "otpauth://totp/Teleport:alice?secret=JBSWY3DPEHPK3PXP&issuer=Teleport&algorithm=SHA256&digits=8&period=60"
+
+// writeQRCode writes a PNG of the QR code of text to path.
+func writeQRCode(t *testing.T, path string, text string) {
+	t.Helper()
+
+	matrix, err := qrcode.NewQRCodeWriter().Encode(text, gozxing.BarcodeFormat_QR_CODE, 256, 256, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, matrix.GetWidth(), matrix.GetHeight()))
+	for y := 0; y < matrix.GetHeight(); y++ {
+		for x := 0; x < matrix.GetWidth(); x++ {
+			if !matrix.Get(x, y) {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = png.Encode(file, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseOTPSecret(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	qrPath := filepath.Join(home, "qr.png")
+	writeQRCode(t, qrPath, testOTPAuthURL)
+
+	qrData, err := os.ReadFile(qrPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromURL := Auth{Secret: "JBSWY3DPEHPK3PXP", Algorithm: "SHA256", Digits: 8, Period: 60, Issuer: "Teleport"}
+
+	tests := []struct {
+		name  string
+		input string
+		want  Auth
+	}{
+		{name: "base32 secret", input: "JBSWY3DPEHPK3PXP", want: Auth{Secret: "JBSWY3DPEHPK3PXP"}},
+		{name: "lowercase with spaces", input: " jbsw y3dp ehpk 3pxp ", want: Auth{Secret: "JBSWY3DPEHPK3PXP"}},
+		{name: "padded", input: "JBSWY3DPEHPK3PXPJBSWY3DP====", want: Auth{Secret: "JBSWY3DPEHPK3PXPJBSWY3DP===="}},
+		{name: "otpauth URI", input: testOTPAuthURL, want: fromURL},
+		{name: "image path", input: qrPath, want: fromURL},
+		{name: "image path in home", input: "~/qr.png", want: fromURL},
+		{name: "data URI", input: "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrData), want: fromURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := ParseOTPSecret(tt.input)
+			if err != nil {
+				t.Fatalf("ParseOTPSecret() error = %v", err)
+			}
+
+			if auth.Secret != tt.want.Secret || auth.Algorithm != tt.want.Algorithm || auth.Digits != tt.want.Digits || auth.Period != tt.want.Period || auth.Issuer != tt.want.Issuer {
+				t.Errorf("ParseOTPSecret() = %+v, want %+v", auth, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOTPSecretErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	notImage := filepath.Join(dir, "notes.txt")
+
+	err := os.WriteFile(notImage, []byte("JBSWY3DPEHPK3PXP"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blank := filepath.Join(dir, "blank.png")
+
+	file, err := os.Create(blank)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = png.Encode(file, image.NewGray(image.Rect(0, 0, 64, 64)))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "secret is empty"},
+		{"not a secret!", "not a base32 secret"},
+		{"JBSWY3DP1", "not a base32 secret"},
+		{"otpauth://hotp/alice?secret=JBSWY3DPEHPK3PXP", "only totp is supported"},
+		{notImage, "unknown format"},
+		{blank, "no QR code found"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseOTPSecret(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseOTPSecret(%q) error = %v, want %q", tt.input, err, tt.want)
+		}
+	}
+}
+
+// An invalid secret is shown under the field and the form stays open.
+func TestLoginShowsSecretError(t *testing.T) {
+	m := InitLoginModel(Profile{Name: "teleport.example.com"}, nil, false, nil)
+	m.passwordInput.SetValue("hunter2")
+	m.secretInput.SetValue("not a secret!")
+	m, _ = m.focus(1)
+
+	model, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = model.(LoginModel)
+
+	if m.stage != "edit" || m.err == nil {
+		t.Fatalf("stage = %q, err = %v, want the form with an error", m.stage, m.err)
+	}
+
+	if !strings.Contains(m.View(), "not a base32 secret") {
+		t.Errorf("View() = %q, want the error", m.View())
+	}
+
+	// Typing again clears it.
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("x")})
+	if m = model.(LoginModel); m.err != nil {
+		t.Errorf("err = %v after typing", m.err)
+	}
+}
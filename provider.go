@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// AuthProvider resolves Auth from an external secret manager instead of
+// keeping a copy of it in tssh. Provider is one of "pass", "gopass", "op"
+// (1Password CLI) or "command". The first three read the entries named by
+// Password and OTPSecret, "command" runs PasswordCmd and either OTPSecretCmd
+// or OTPCodeCmd through the shell.
+type AuthProvider struct {
+	Provider  string `json:"provider"`
+	Password  string `json:"password"`
+	OTPSecret string `json:"otp_secret"`
+
+	PasswordCmd  string `json:"password_cmd"`
+	OTPSecretCmd string `json:"otp_secret_cmd"`
+	OTPCodeCmd   string `json:"otp_code_cmd"`
+}
+
+// ProviderStore is a read-only AuthStore backed by an AuthProvider.
+type ProviderStore struct {
+	provider AuthProvider
+}
+
+// GetAuthProvider returns the provider configured for p, or nil when Auth
+// should come from the local store.
+func GetAuthProvider(p Profile) (*AuthProvider, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return config.Profile(p.Name).Auth, nil
+}
+
+func (s ProviderStore) Name() string {
+	return "provider " + s.provider.Provider
+}
+
+func (s ProviderStore) Store(auth Auth) error {
+	return s.readOnlyError()
+}
+
+func (s ProviderStore) Delete() error {
+	return s.readOnlyError()
+}
+
+func (s ProviderStore) readOnlyError() error {
+	configPath, err := GetConfigPath()
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("auth for this profile is provided by %s, edit %s instead", s.provider.Provider, configPath)
+}
+
+func (s ProviderStore) Get() (*Auth, error) {
+	auth, err := s.provider.resolve()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.provider.Provider, err)
+	}
+
+	return auth, nil
+}
+
+func (p AuthProvider) resolve() (*Auth, error) {
+	passwordCmd, secretCmd, codeCmd, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+
+	password, err := runProviderCmd(passwordCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &Auth{Password: password}
+
+	if codeCmd != nil {
+		auth.code = func(time.Time) (string, error) {
+			code, err := runProviderCmd(codeCmd)
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", p.Provider, err)
+			}
+
+			return code, nil
+		}
+
+		return auth, nil
+	}
+
+	secret, err := runProviderCmd(secretCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// pass-otp and most password managers keep the whole otpauth:// URI.
+	if strings.HasPrefix(secret, "otpauth://") {
+		otpAuth, err := ParseOTPAuthURL(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		otpAuth.Password = password
+
+		return &otpAuth, nil
+	}
+
+	auth.Secret = secret
+
+	return auth, nil
+}
+
+func (p AuthProvider) commands() (password []string, secret []string, code []string, err error) {
+	entry := func(name string, value string) error {
+		if value == "" {
+			return fmt.Errorf("'%s' is not configured", name)
+		}
+
+		return nil
+	}
+
+	switch p.Provider {
+	case "pass", "gopass", "op":
+		err = errors.Join(entry("password", p.Password), entry("otp_secret", p.OTPSecret))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		read := map[string][]string{
+			"pass":   {"pass", "show"},
+			"gopass": {"gopass", "show", "-o"},
+			"op":     {"op", "read"},
+		}[p.Provider]
+
+		password = append(append([]string{}, read...), p.Password)
+		secret = append(append([]string{}, read...), p.OTPSecret)
+
+		return password, secret, nil, nil
+	case "command":
+		err = entry("password_cmd", p.PasswordCmd)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if p.OTPCodeCmd != "" {
+			return []string{"sh", "-c", p.PasswordCmd}, nil, []string{"sh", "-c", p.OTPCodeCmd}, nil
+		}
+
+		err = entry("otp_secret_cmd or otp_code_cmd", p.OTPSecretCmd)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return []string{"sh", "-c", p.PasswordCmd}, []string{"sh", "-c", p.OTPSecretCmd}, nil, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unknown provider %q, expected pass, gopass, op or command", p.Provider)
+	}
+}
+
+// runProviderCmd returns the first line printed by the command, which is
+// where pass and friends put the secret itself.
+func runProviderCmd(args []string) (string, error) {
+	var stderr bytes.Buffer
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Stderr = &stderr
+
+	out, err := c.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message != "" {
+			return "", fmt.Errorf("'%s' failed: %w: %s", strings.Join(args, " "), err, message)
+		}
+
+		return "", fmt.Errorf("'%s' failed: %w", strings.Join(args, " "), err)
+	}
+
+	line, _, _ := strings.Cut(string(out), "\n")
+	line = strings.TrimSpace(line)
+
+	if line == "" {
+		return "", fmt.Errorf("'%s' printed nothing", strings.Join(args, " "))
+	}
+
+	return line, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gravitational/teleport/api/types"
+)
+
+var traitVariable = regexp.MustCompile(`^\{\{\s*(internal|external)\.([\w-]+)\s*\}\}$`)
+
+// NodeLogins works out the logins roles allow on a node the way Teleport
+// does: an allow rule grants its logins on nodes matching its node_labels,
+// a deny rule takes away every login on nodes matching its node_labels and
+// its logins on all nodes.
+func NodeLogins(roles []types.Role, traits map[string][]string, labels map[string]string) []string {
+	logins := []string{}
+
+	for _, role := range roles {
+		if !matchNodeLabels(role.GetNodeLabels(types.Allow), traits, labels) {
+			continue
+		}
+
+		for _, login := range expandTraits(role.GetLogins(types.Allow), traits) {
+			if !slices.Contains(logins, login) {
+				logins = append(logins, login)
+			}
+		}
+	}
+
+	for _, role := range roles {
+		if matchNodeLabels(role.GetNodeLabels(types.Deny), traits, labels) {
+			return []string{}
+		}
+
+		denied := expandTraits(role.GetLogins(types.Deny), traits)
+		logins = slices.DeleteFunc(logins, func(login string) bool {
+			return slices.Contains(denied, login)
+		})
+	}
+
+	return logins
+}
+
+// RoleLogins returns every login the roles allow somewhere.
+func RoleLogins(roles []types.Role, traits map[string][]string) []string {
+	logins := []string{}
+
+	for _, role := range roles {
+		for _, login := range expandTraits(role.GetLogins(types.Allow), traits) {
+			if !slices.Contains(logins, login) {
+				logins = append(logins, login)
+			}
+		}
+	}
+
+	for _, role := range roles {
+		denied := expandTraits(role.GetLogins(types.Deny), traits)
+		logins = slices.DeleteFunc(logins, func(login string) bool {
+			return slices.Contains(denied, login)
+		})
+	}
+
+	return logins
+}
+
+// expandTraits replaces {{internal.name}} and {{external.name}} with the
+// values of the user trait. Other templates can't be evaluated here and are
+// dropped.
+func expandTraits(values []string, traits map[string][]string) []string {
+	expanded := []string{}
+
+	for _, value := range values {
+		if !strings.Contains(value, "{{") {
+			expanded = append(expanded, value)
+
+			continue
+		}
+
+		match := traitVariable.FindStringSubmatch(value)
+		if match != nil {
+			expanded = append(expanded, traits[match[2]]...)
+		}
+	}
+
+	return expanded
+}
+
+// matchNodeLabels reports whether every key of selector is set on the node
+// to one of its values. Values are globs, or regular expressions when
+// wrapped in ^ and $, and "*": "*" matches any node.
+func matchNodeLabels(selector types.Labels, traits map[string][]string, labels map[string]string) bool {
+	if len(selector) == 0 {
+		return false
+	}
+
+	if slices.Contains(selector[types.Wildcard], types.Wildcard) {
+		return true
+	}
+
+	for key, values := range selector {
+		value, ok := labels[key]
+		if !ok {
+			return false
+		}
+
+		values := expandTraits(values, traits)
+		if slices.Contains(values, types.Wildcard) {
+			continue
+		}
+
+		if !slices.ContainsFunc(values, func(pattern string) bool {
+			return matchLabelValue(pattern, value)
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// labelPatterns caches the compiled label value patterns by pattern, nil when
+// it is not a valid regular expression. The same few patterns of the roles
+// and the config are matched against every node on every list update.
+var labelPatterns sync.Map
+
+func matchLabelValue(pattern string, value string) bool {
+	re := labelPattern(pattern)
+
+	return re != nil && re.MatchString(value)
+}
+
+func labelPattern(pattern string) *regexp.Regexp {
+	if re, ok := labelPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+
+	expr := pattern
+	if !strings.HasPrefix(pattern, "^") || !strings.HasSuffix(pattern, "$") {
+		expr = "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		re = nil
+	}
+
+	labelPatterns.Store(pattern, re)
+
+	return re
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gravitational/teleport/api/types"
+)
+
+func role(allow types.RoleConditions, deny types.RoleConditions) types.Role {
+	return &types.RoleV6{Spec: types.RoleSpecV6{Allow: allow, Deny: deny}}
+}
+
+func TestNodeLogins(t *testing.T) {
+	traits := map[string][]string{
+		"logins": {"alice", "deploy"},
+		"env":    {"staging"},
+	}
+
+	web := map[string]string{"env": "prod", "role": "web-frontend"}
+	db := map[string]string{"env": "prod", "role": "db-12"}
+	staging := map[string]string{"env": "staging", "role": "web-frontend"}
+
+	tests := []struct {
+		name   string
+		roles  []types.Role
+		labels map[string]string
+		want   []string
+	}{
+		{
+			name: "matching labels",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"root"}, NodeLabels: types.Labels{"env": {"prod"}}}, types.RoleConditions{}),
+			},
+			labels: web,
+			want:   []string{"root"},
+		},
+		{
+			name: "other labels",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"root"}, NodeLabels: types.Labels{"env": {"prod"}}}, types.RoleConditions{}),
+			},
+			labels: staging,
+			want:   []string{},
+		},
+		{
+			name: "no node labels",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"root"}}, types.RoleConditions{}),
+			},
+			labels: web,
+			want:   []string{},
+		},
+		{
+			name: "wildcard",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"ubuntu"}, NodeLabels: types.Labels{"*": {"*"}}}, types.RoleConditions{}),
+			},
+			labels: staging,
+			want:   []string{"ubuntu"},
+		},
+		{
+			name: "glob and regexp values",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"www"}, NodeLabels: types.Labels{"role": {"web-*"}}}, types.RoleConditions{}),
+				role(types.RoleConditions{Logins: []string{"postgres"}, NodeLabels: types.Labels{"role": {"^db-[0-9]+$"}}}, types.RoleConditions{}),
+			},
+			labels: db,
+			want:   []string{"postgres"},
+		},
+		{
+			name: "logins and labels from traits",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"{{internal.logins}}", "{{email.local(external.email)}}"}, NodeLabels: types.Labels{"env": {"{{external.env}}"}}}, types.RoleConditions{}),
+			},
+			labels: staging,
+			want:   []string{"alice", "deploy"},
+		},
+		{
+			name: "denied login",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"root", "ubuntu"}, NodeLabels: types.Labels{"*": {"*"}}}, types.RoleConditions{}),
+				role(types.RoleConditions{}, types.RoleConditions{Logins: []string{"root"}}),
+			},
+			labels: web,
+			want:   []string{"ubuntu"},
+		},
+		{
+			name: "denied node",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"ubuntu"}, NodeLabels: types.Labels{"*": {"*"}}}, types.RoleConditions{}),
+				role(types.RoleConditions{}, types.RoleConditions{NodeLabels: types.Labels{"role": {"db-*"}}}),
+			},
+			labels: db,
+			want:   []string{},
+		},
+		{
+			name: "logins of several roles",
+			roles: []types.Role{
+				role(types.RoleConditions{Logins: []string{"ubuntu"}, NodeLabels: types.Labels{"env": {"prod", "staging"}}}, types.RoleConditions{}),
+				role(types.RoleConditions{Logins: []string{"ubuntu", "www"}, NodeLabels: types.Labels{"env": {"prod"}, "role": {"web-*"}}}, types.RoleConditions{}),
+			},
+			labels: web,
+			want:   []string{"ubuntu", "www"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NodeLogins(tt.roles, traits, tt.labels)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("NodeLogins() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchLabelValue(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{"prod", "prod", true},
+		{"prod", "production", false},
+		{"web-*", "web-1", true},
+		{"web-*", "db-1", false},
+		{"*.example.com", "a.example.com", true},
+		{"a.example.com", "abexample.com", false},
+		{"^db-[0-9]+$", "db-12", true},
+		{"^db-[0-9]+$", "db-x", false},
+		{"^db-[$", "db-[", false},
+	}
+
+	for _, tt := range tests {
+		// The second call gets the cached pattern.
+		for range 2 {
+			if got := matchLabelValue(tt.pattern, tt.value); got != tt.want {
+				t.Errorf("matchLabelValue(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+			}
+		}
+	}
+}
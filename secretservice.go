@@ -0,0 +1,160 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/keybase/dbus"
+	"github.com/keybase/go-keychain/secretservice"
+)
+
+// SecretServiceStore keeps Auth in the default collection of the freedesktop
+// Secret Service (gnome-keyring, KeePassXC, ...) reachable over the session bus.
+type SecretServiceStore struct {
+	account string
+}
+
+// NewSystemAuthStore returns the Secret Service item for account, "" is the
+// item used before auth was stored per profile.
+func NewSystemAuthStore(account string) AuthStore {
+	if account == "" {
+		account = "tssh"
+	}
+
+	return SecretServiceStore{account}
+}
+
+func (s SecretServiceStore) attributes() map[string]string {
+	return map[string]string{
+		"service": "tssh",
+		"account": s.account,
+	}
+}
+
+// errSecretServiceUnavailable is returned when there is no session bus or
+// nothing on it provides the Secret Service.
+var errSecretServiceUnavailable = errors.New("Secret Service unavailable")
+
+func openSecretService() (*secretservice.SecretService, *secretservice.Session, error) {
+	srv, err := secretservice.NewService()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", errSecretServiceUnavailable, err)
+	}
+
+	session, err := srv.OpenSession(secretservice.AuthenticationDHAES)
+
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) && (dbusErr.Name == "org.freedesktop.DBus.Error.ServiceUnknown" || dbusErr.Name == "org.freedesktop.DBus.Error.NameHasNoOwner") {
+		return nil, nil, fmt.Errorf("%w: %w", errSecretServiceUnavailable, err)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return srv, session, nil
+}
+
+func (SecretServiceStore) Name() string {
+	return "Secret Service"
+}
+
+func (s SecretServiceStore) Store(auth Auth) error {
+	data, err := json.Marshal(auth)
+	if err != nil {
+		return err
+	}
+
+	srv, session, err := openSecretService()
+	if err != nil {
+		return err
+	}
+	defer srv.CloseSession(session)
+
+	err = srv.Unlock([]dbus.ObjectPath{secretservice.DefaultCollection})
+	if err != nil {
+		return err
+	}
+
+	secret, err := session.NewSecret(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = srv.CreateItem(
+		secretservice.DefaultCollection,
+		secretservice.NewSecretProperties("Teleport Login for tssh", s.attributes()),
+		secret,
+		secretservice.ReplaceBehaviorReplace,
+	)
+
+	return err
+}
+
+// Get finds nothing stored when the Secret Service is unavailable, so login
+// falls back to an interactive 'tsh login' on headless boxes.
+func (s SecretServiceStore) Get() (*Auth, error) {
+	srv, session, err := openSecretService()
+	if errors.Is(err, errSecretServiceUnavailable) {
+		log.Printf("no auth read from %s: %v", s.Name(), err)
+
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer srv.CloseSession(session)
+
+	items, err := srv.SearchCollection(secretservice.DefaultCollection, s.attributes())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items) < 1 {
+		return nil, nil
+	}
+
+	err = srv.Unlock(items[:1])
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := srv.GetSecret(items[0], *session)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &Auth{}
+
+	err = json.Unmarshal(data, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth, nil
+}
+
+func (s SecretServiceStore) Delete() error {
+	srv, session, err := openSecretService()
+	if err != nil {
+		return err
+	}
+	defer srv.CloseSession(session)
+
+	items, err := srv.SearchCollection(secretservice.DefaultCollection, s.attributes())
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		err = srv.DeleteItem(item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,329 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/keybase/dbus"
+	"github.com/keybase/go-keychain/secretservice"
+	"golang.org/x/crypto/hkdf"
+)
+
+// startSessionBus runs a private dbus-daemon and points the Secret Service
+// client at it.
+func startSessionBus(t *testing.T) {
+	t.Helper()
+
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	c := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address",
+		"--address=unix:path="+filepath.Join(t.TempDir(), "bus"))
+
+	out, err := c.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		c.Process.Kill()
+		c.Wait()
+	})
+
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", strings.TrimSpace(address))
+}
+
+type fakeSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+type fakeItem struct {
+	attributes map[string]string
+	secret     []byte
+}
+
+// fakeSecretService is a stand-in for gnome-keyring with the part of the
+// Secret Service API the store uses, items are kept in memory.
+type fakeSecretService struct {
+	conn *dbus.Conn
+
+	mu    sync.Mutex
+	keys  map[dbus.ObjectPath][]byte
+	items map[dbus.ObjectPath]fakeItem
+	next  int
+}
+
+func newFakeSecretService(t *testing.T) *fakeSecretService {
+	t.Helper()
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	s := &fakeSecretService{
+		conn:  conn,
+		keys:  map[dbus.ObjectPath][]byte{},
+		items: map[dbus.ObjectPath]fakeItem{},
+	}
+
+	err = conn.Export(s, secretservice.SecretServiceObjectPath, "org.freedesktop.Secret.Service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = conn.Export(s, secretservice.DefaultCollection, "org.freedesktop.Secret.Collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := conn.RequestName(secretservice.SecretServiceInterface, dbus.NameFlagDoNotQueue)
+	if err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("can't own %s: %v", secretservice.SecretServiceInterface, err)
+	}
+
+	return s
+}
+
+// OpenSession uses 1 as its private key, so its public key is the generator
+// and the shared secret is the public key of the client.
+func (s *fakeSecretService) OpenSession(mode string, input dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if mode != string(secretservice.AuthenticationDHAES) {
+		return dbus.Variant{}, "", dbus.NewError("org.freedesktop.DBus.Error.NotSupported", nil)
+	}
+
+	public, _ := input.Value().([]byte)
+
+	key := make([]byte, 16)
+	io.ReadFull(hkdf.New(sha256.New, new(big.Int).SetBytes(public).Bytes(), nil, nil), key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.next++
+	path := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/session/%d", s.next))
+	s.keys[path] = key
+
+	return dbus.MakeVariant(big.NewInt(2).Bytes()), path, nil
+}
+
+func (s *fakeSecretService) Unlock(items []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return items, secretservice.NullPrompt, nil
+}
+
+func (s *fakeSecretService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, *dbus.Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := []dbus.ObjectPath{}
+	for path, item := range s.items {
+		if reflect.DeepEqual(item.attributes, attributes) {
+			found = append(found, path)
+		}
+	}
+
+	return found, nil
+}
+
+func (s *fakeSecretService) CreateItem(properties map[string]dbus.Variant, secret fakeSecret, replace bool) (dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	attributes, _ := properties["org.freedesktop.Secret.Item.Attributes"].Value().(map[string]string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plaintext, err := decryptSecret(s.keys[secret.Session], secret.Parameters, secret.Value)
+	if err != nil {
+		return "", "", dbus.MakeFailedError(err)
+	}
+
+	for path, item := range s.items {
+		if replace && reflect.DeepEqual(item.attributes, attributes) {
+			s.items[path] = fakeItem{attributes, plaintext}
+
+			return path, secretservice.NullPrompt, nil
+		}
+	}
+
+	s.next++
+	path := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/secrets/collection/login/%d", s.next))
+	s.items[path] = fakeItem{attributes, plaintext}
+
+	err = s.conn.Export(&fakeItemObject{s, path}, path, "org.freedesktop.Secret.Item")
+	if err != nil {
+		return "", "", dbus.MakeFailedError(err)
+	}
+
+	return path, secretservice.NullPrompt, nil
+}
+
+type fakeItemObject struct {
+	service *fakeSecretService
+	path    dbus.ObjectPath
+}
+
+func (o *fakeItemObject) GetSecret(session dbus.ObjectPath) (fakeSecret, *dbus.Error) {
+	o.service.mu.Lock()
+	defer o.service.mu.Unlock()
+
+	iv, ciphertext, err := encryptSecret(o.service.keys[session], o.service.items[o.path].secret)
+	if err != nil {
+		return fakeSecret{}, dbus.MakeFailedError(err)
+	}
+
+	return fakeSecret{session, iv, ciphertext, "application/octet-stream"}, nil
+}
+
+func (o *fakeItemObject) Delete() (dbus.ObjectPath, *dbus.Error) {
+	o.service.mu.Lock()
+	delete(o.service.items, o.path)
+	o.service.mu.Unlock()
+
+	o.service.conn.Export(nil, o.path, "org.freedesktop.Secret.Item")
+
+	return secretservice.NullPrompt, nil
+}
+
+func encryptSecret(key []byte, plaintext []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(bytes.Clone(plaintext), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(iv)
+
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	return iv, ciphertext, nil
+}
+
+func decryptSecret(key []byte, iv []byte, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("malformed secret")
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padding := int(plaintext[len(plaintext)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, errors.New("malformed padding")
+	}
+
+	return plaintext[:len(plaintext)-padding], nil
+}
+
+func TestSecretServiceStore(t *testing.T) {
+	startSessionBus(t)
+	newFakeSecretService(t)
+
+	store := NewSystemAuthStore("teleport.example.com")
+	other := NewSystemAuthStore("other.example.com")
+
+	auth, err := store.Get()
+	if err != nil || auth != nil {
+		t.Fatalf("Get() before Store = %v, %v, want nothing stored", auth, err)
+	}
+
+	want := Auth{Password: "hunter2", Secret: "JBSWY3DPEHPK3PXP", Digits: 8}
+
+	err = store.Store(want)
+	if err != nil {
+		t.Fatalf("Store() = %v", err)
+	}
+
+	auth, err = store.Get()
+	if err != nil || auth == nil || !reflect.DeepEqual(*auth, want) {
+		t.Fatalf("Get() = %v, %v, want %v", auth, err, want)
+	}
+
+	auth, err = other.Get()
+	if err != nil || auth != nil {
+		t.Fatalf("Get() of another account = %v, %v, want nothing stored", auth, err)
+	}
+
+	err = store.Delete()
+	if err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+
+	auth, err = store.Get()
+	if err != nil || auth != nil {
+		t.Fatalf("Get() after Delete = %v, %v, want nothing stored", auth, err)
+	}
+}
+
+func TestSecretServiceUnavailable(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T)
+	}{
+		{
+			name: "no session bus",
+			setup: func(t *testing.T) {
+				t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path="+filepath.Join(t.TempDir(), "missing"))
+			},
+		},
+		{
+			name:  "no Secret Service on the bus",
+			setup: startSessionBus,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(t)
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+			auth, err := GetAuth(Profile{Name: "teleport.example.com"})
+			if err != nil || auth != nil {
+				t.Fatalf("GetAuth() = %v, %v, want nothing stored", auth, err)
+			}
+
+			err = NewSystemAuthStore("teleport.example.com").Store(Auth{Password: "hunter2"})
+			if !errors.Is(err, errSecretServiceUnavailable) {
+				t.Fatalf("Store() = %v, want %v", err, errSecretServiceUnavailable)
+			}
+		})
+	}
+}
@@ -3,25 +3,99 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"slices"
+	"strings"
+	"time"
 
 	"github.com/gravitational/teleport/api/client"
 	"github.com/gravitational/teleport/api/client/proto"
+	presencev1 "github.com/gravitational/teleport/api/gen/proto/go/teleport/presence/v1"
 	"github.com/gravitational/teleport/api/types"
+	"github.com/gravitational/trace"
 )
 
+// serversInfoVersion is bumped whenever servers.json gains data that older
+// caches lack, so they get refetched once.
+const serversInfoVersion = 3
+
 type ServersInfo struct {
-	DefaultLogin        string     `json:"default_login"`
-	Logins              []string   `json:"logins"`
-	Servers             []string   `json:"servers"`
-	RecentlyUsedServers [10]string `json:"recently_used_servers"`
+	Version      int        `json:"version"`
+	FetchedAt    time.Time  `json:"fetched_at"`
+	Filter       NodeFilter `json:"filter"`
+	DefaultLogin string     `json:"default_login"`
+	Logins       []string   `json:"logins"`
+	Servers      []Server   `json:"servers"`
+
+	// RecentlyUsedServers is only read to move it to the History.
+	RecentlyUsedServers []string `json:"recently_used_servers,omitempty"`
+
+	// HostLogins are the logins chosen for single servers, by Server.Key.
+	HostLogins map[string]string `json:"host_logins,omitempty"`
+}
+
+// Server is a cached node together with the cluster it belongs to, root or
+// leaf. Teleport nodes have no OS field, OS comes from an "os" label when the
+// node has one.
+type Server struct {
+	UUID     string            `json:"uuid,omitempty"`
+	Hostname string            `json:"hostname"`
+	Cluster  string            `json:"cluster"`
+	Addr     string            `json:"addr,omitempty"`
+	OS       string            `json:"os,omitempty"`
+	Version  string            `json:"version,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	// Logins are the logins the user's roles allow on the node, nil when
+	// they could not be worked out.
+	Logins []string `json:"logins"`
+}
+
+// Key identifies the server for the logins chosen per host.
+func (s Server) Key() string {
+	return s.Cluster + "/" + s.Hostname
+}
+
+// AllowsLogin tells whether login is known to be allowed on the server.
+func (s Server) AllowsLogin(login string) bool {
+	return s.Logins == nil || slices.Contains(s.Logins, login)
 }
 
-func FetchServersInfo(cr client.Credentials) (*ServersInfo, error) {
-	ctx := context.Background()
+// UnmarshalJSON also reads caches written before leaf clusters were
+// supported, where a server was just its hostname.
+func (s *Server) UnmarshalJSON(data []byte) error {
+	var hostname string
+	if json.Unmarshal(data, &hostname) == nil {
+		*s = Server{Hostname: hostname}
+
+		return nil
+	}
+
+	type server Server
+
+	return json.Unmarshal(data, (*server)(s))
+}
+
+// FetchProgress is reported by FetchServersInfo after every page of nodes,
+// counting all clusters fetched so far.
+type FetchProgress struct {
+	Cluster string
+	Pages   int
+	Nodes   int
+}
+
+func (p FetchProgress) String() string {
+	return fmt.Sprintf("%d nodes, %d pages (%s)", p.Nodes, p.Pages, p.Cluster)
+}
 
+// FetchServersInfo lists the nodes matching filter in the root cluster of p
+// and in every leaf cluster trusted by it.
+func FetchServersInfo(ctx context.Context, p Profile, cr client.Credentials, filter NodeFilter, progress func(FetchProgress)) (*ServersInfo, error) {
 	clt, err := client.New(ctx, client.Config{
 		Credentials: []client.Credentials{
 			cr,
@@ -32,39 +106,115 @@ func FetchServersInfo(cr client.Credentials) (*ServersInfo, error) {
 	}
 	defer clt.Close()
 
-	roles, err := clt.GetCurrentUserRoles(context.Background())
+	roles, err := clt.GetCurrentUserRoles(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	logins := make([]string, 0)
-	for _, role := range roles {
-		for _, login := range role.GetLogins(types.Allow) {
+	user, err := clt.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	traits := user.GetTraits()
+
+	ping, err := clt.Ping(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fetched := FetchProgress{}
+	onPage := func(cluster string, nodes int) {
+		fetched.Cluster = cluster
+		fetched.Pages++
+		fetched.Nodes += nodes
+
+		progress(fetched)
+	}
+
+	servers, err := listServers(ctx, clt, ping.ClusterName, filter, roles, traits, onPage)
+	if err != nil {
+		return nil, err
+	}
+
+	leafClusters, err := listLeafClusters(ctx, clt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cluster := range leafClusters {
+		leafServers, err := fetchLeafServers(ctx, p, cr, cluster, filter, traits, onPage)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		// A leaf that can't be reached, e.g. without TLS routing, is left
+		// out like an offline one instead of losing the root nodes.
+		if err != nil {
+			log.Printf("skipping leaf cluster %s: %v", cluster, err)
+
+			continue
+		}
+
+		servers = append(servers, leafServers...)
+	}
+
+	// Leaf clusters map roles to their own, which may allow other logins.
+	logins := RoleLogins(roles, traits)
+	for _, server := range servers {
+		for _, login := range server.Logins {
 			if !slices.Contains(logins, login) {
 				logins = append(logins, login)
 			}
 		}
 	}
 
-	servers := make([]string, 0)
+	return &ServersInfo{
+		FetchedAt: time.Now(),
+		Filter:    filter,
+		Logins:    logins,
+		Servers:   servers,
+	}, nil
+}
+
+func listServers(ctx context.Context, clt *client.Client, cluster string, filter NodeFilter, roles []types.Role, traits map[string][]string, onPage func(cluster string, nodes int)) ([]Server, error) {
+	servers := make([]Server, 0)
 
 	req := proto.ListResourcesRequest{
-		ResourceType: types.KindNode,
-		Limit:        500,
+		ResourceType:        types.KindNode,
+		Limit:               500,
+		PredicateExpression: filter.Where,
+		Labels:              filter.Labels,
+		SearchKeywords:      filter.SearchKeywords,
 	}
 
 	for {
-		res, err := clt.ListResources(context.Background(), req)
+		res, err := clt.ListResources(ctx, req)
 		if err != nil {
 			return nil, err
 		}
 
-		for _, node := range res.Resources {
-			name := types.FriendlyName(node)
+		for _, resource := range res.Resources {
+			server := Server{
+				UUID:     resource.GetName(),
+				Hostname: types.FriendlyName(resource),
+				Cluster:  cluster,
+				Labels:   resource.GetAllLabels(),
+			}
 
-			servers = append(servers, name)
+			if node, ok := resource.(types.Server); ok {
+				server.Addr = node.GetAddr()
+				server.Version = node.GetTeleportVersion()
+			}
+
+			server.OS = server.Labels["os"]
+			server.Logins = NodeLogins(roles, traits, server.Labels)
+
+			servers = append(servers, server)
 		}
 
+		onPage(cluster, len(res.Resources))
+
 		if res.NextKey == "" {
 			break
 		}
@@ -72,10 +222,108 @@ func FetchServersInfo(cr client.Credentials) (*ServersInfo, error) {
 		req.StartKey = res.NextKey
 	}
 
-	return &ServersInfo{
-		Logins:  logins,
-		Servers: servers,
-	}, nil
+	return servers, nil
+}
+
+// listLeafClusters returns the online leaf clusters. Users that may not list
+// them, or an auth server too old to tell, just get the root cluster.
+func listLeafClusters(ctx context.Context, clt *client.Client) ([]string, error) {
+	clusters := make([]string, 0)
+
+	req := presencev1.ListRemoteClustersRequest{
+		PageSize: 100,
+	}
+
+	for {
+		res, err := clt.PresenceServiceClient().ListRemoteClusters(ctx, &req)
+		if trace.IsAccessDenied(err) || trace.IsNotImplemented(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cluster := range res.RemoteClusters {
+			if cluster.GetConnectionStatus() == "offline" {
+				continue
+			}
+
+			clusters = append(clusters, cluster.GetName())
+		}
+
+		if res.NextPageToken == "" {
+			break
+		}
+
+		req.PageToken = res.NextPageToken
+	}
+
+	return clusters, nil
+}
+
+// fetchLeafServers reaches the auth server of a leaf cluster through the
+// root proxy, the same way 'tsh ls --cluster' does.
+func fetchLeafServers(ctx context.Context, p Profile, cr client.Credentials, cluster string, filter NodeFilter, traits map[string][]string, onPage func(cluster string, nodes int)) ([]Server, error) {
+	clt, err := client.New(ctx, client.Config{
+		Addrs: []string{p.Proxy},
+		Credentials: []client.Credentials{
+			cr,
+		},
+		ALPNSNIAuthDialClusterName: cluster,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer clt.Close()
+
+	roles, err := clt.GetCurrentUserRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return listServers(ctx, clt, cluster, filter, roles, traits, onPage)
+}
+
+// ConnectTarget is what to pass to 'tsh ssh' for server: its hostname, or its
+// UUID when another node of the cluster has the same hostname.
+func (info *ServersInfo) ConnectTarget(server Server) string {
+	if server.UUID == "" {
+		return server.Hostname
+	}
+
+	for _, other := range info.Servers {
+		if other.Hostname == server.Hostname && other.Cluster == server.Cluster && other.UUID != server.UUID {
+			return server.UUID
+		}
+	}
+
+	return server.Hostname
+}
+
+// LoginFor returns the login to connect to server with: the one chosen for
+// it, the one of the first matching rule or the default login.
+func (info *ServersInfo) LoginFor(server Server, rules []LoginRule) string {
+	if login, ok := info.HostLogins[server.Key()]; ok {
+		return login
+	}
+
+	for _, rule := range rules {
+		if rule.Matches(server.Hostname, server.Labels) {
+			return rule.Login
+		}
+	}
+
+	return info.DefaultLogin
+}
+
+func (info *ServersInfo) FindServer(uuid string, hostname string, cluster string) (Server, bool) {
+	for _, server := range info.Servers {
+		if server.UUID == uuid && server.Hostname == hostname && server.Cluster == cluster {
+			return server, true
+		}
+	}
+
+	return Server{}, false
 }
 
 func GetCacheDir() (string, error) {
@@ -87,13 +335,15 @@ func GetCacheDir() (string, error) {
 	return filepath.Join(cacheDir, "tssh"), nil
 }
 
-func GetCachePath() (string, error) {
+// GetCachePath returns the servers cache of p. Every profile has its own
+// folder, so switching profiles never mixes servers, logins or recents.
+func GetCachePath(p Profile) (string, error) {
 	cacheDir, err := GetCacheDir()
 	if err != nil {
 		return "", err
 	}
 
-	return filepath.Join(cacheDir, "servers.json"), nil
+	return filepath.Join(cacheDir, "profiles", p.Key(), "servers.json"), nil
 }
 
 func CreateCachePath() error {
@@ -105,13 +355,16 @@ func CreateCachePath() error {
 	return os.MkdirAll(cacheDir, os.ModeDir|0755)
 }
 
-func GetServersInfoFromCache() (*ServersInfo, error) {
-	filepath, err := GetCachePath()
+func GetServersInfoFromCache(p Profile) (*ServersInfo, error) {
+	filepath, err := GetCachePath(p)
 	if err != nil {
 		return nil, err
 	}
 
 	file, err := os.ReadFile(filepath)
+	if errors.Is(err, fs.ErrNotExist) && moveLegacyServersInfo(p, filepath) == nil {
+		file, err = os.ReadFile(filepath)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -125,23 +378,81 @@ func GetServersInfoFromCache() (*ServersInfo, error) {
 	return &serversInfo, nil
 }
 
-func StoreServersInfo(info *ServersInfo) error {
-	err := CreateCachePath()
+func StoreServersInfo(p Profile, info *ServersInfo) error {
+	info.Version = serversInfoVersion
+	info.RecentlyUsedServers = nil
+
+	data, err := json.Marshal(info)
 	if err != nil {
 		return err
 	}
 
-	data, err := json.Marshal(info)
+	path, err := GetCachePath(p)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return storeHostnames(p, info)
+}
+
+// GetHostnamesPath returns the list of cached hostnames, one per line. Shell
+// completion reads it instead of the whole cache.
+func GetHostnamesPath(p Profile) (string, error) {
+	cachePath, err := GetCachePath(p)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(cachePath), "hostnames"), nil
+}
+
+func storeHostnames(p Profile, info *ServersInfo) error {
+	hostnames := make([]string, 0, len(info.Servers))
+	for _, server := range info.Servers {
+		hostnames = append(hostnames, server.Hostname)
+	}
+
+	slices.Sort(hostnames)
+	hostnames = slices.Compact(hostnames)
+
+	path, err := GetHostnamesPath(p)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(strings.Join(hostnames, "\n")+"\n"), 0644)
+}
+
+// moveLegacyServersInfo moves the cache tssh kept before every profile had
+// its own to path, the cache of p. tssh only worked with the current tsh
+// profile then, so any other profile fetches its servers instead. The cache
+// is moved rather than read, its recents stay until the history has them.
+func moveLegacyServersInfo(p Profile, path string) error {
+	if !p.IsCurrent() {
+		return fs.ErrNotExist
+	}
+
+	cacheDir, err := GetCacheDir()
 	if err != nil {
 		return err
 	}
 
-	filepath, err := GetCachePath()
+	err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(filepath, data, 0644)
+	return os.Rename(filepath.Join(cacheDir, "servers.json"), path)
 }
 
 func DeleteServersInto() error {
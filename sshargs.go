@@ -0,0 +1,61 @@
+package main
+
+import "slices"
+
+// tshValueFlags are the tsh ssh flags taking the next argument as their value.
+var tshValueFlags = []string{
+	"-l", "--login",
+	"-p", "--port",
+	"-L", "--forward",
+	"-R", "--remote-forward",
+	"-D", "--dynamic-forward",
+	"-o", "--option",
+	"--cluster",
+	"--request-reason",
+	"--request-id",
+	"--proxy",
+	"--user",
+}
+
+// SSHArgsFor returns the tsh ssh arguments the first rule matching server
+// sets.
+func SSHArgsFor(server Server, rules []SSHArgsRule) []string {
+	for _, rule := range rules {
+		if rule.Matches(server.Hostname, server.Labels) {
+			return rule.Args
+		}
+	}
+
+	return nil
+}
+
+// splitSSHArgs splits tsh ssh arguments into the flags, which go before the
+// target, and the remote command, which goes after it. The command starts at
+// the first argument that is neither a flag nor a flag value, or after "--".
+func splitSSHArgs(args []string) ([]string, []string) {
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--":
+			return args[:i], args[i+1:]
+		case slices.Contains(tshValueFlags, args[i]):
+			i++
+		case len(args[i]) < 2 || args[i][0] != '-':
+			return args[:i], args[i:]
+		}
+	}
+
+	return args, nil
+}
+
+// mergeSSHArgs adds args to the defaults: the flags of both are kept, the
+// command of args replaces the default one.
+func mergeSSHArgs(defaults []string, args []string) ([]string, []string) {
+	defaultFlags, defaultCommand := splitSSHArgs(defaults)
+	flags, command := splitSSHArgs(args)
+
+	if len(command) == 0 {
+		command = defaultCommand
+	}
+
+	return append(slices.Clone(defaultFlags), flags...), command
+}
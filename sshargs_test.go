@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSSHArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		flags   []string
+		command []string
+	}{
+		{nil, nil, nil},
+		{[]string{"-A"}, []string{"-A"}, nil},
+		{[]string{"-A", "uptime"}, []string{"-A"}, []string{"uptime"}},
+		{[]string{"-L", "8080:localhost:80", "uptime"}, []string{"-L", "8080:localhost:80"}, []string{"uptime"}},
+		{[]string{"--login", "root", "-A"}, []string{"--login", "root", "-A"}, nil},
+		{[]string{"-A", "--", "-x", "ls"}, []string{"-A"}, []string{"-x", "ls"}},
+		{[]string{"ls", "-la"}, []string{}, []string{"ls", "-la"}},
+		{[]string{"-", "ls"}, []string{}, []string{"-", "ls"}},
+	}
+
+	for _, tt := range tests {
+		flags, command := splitSSHArgs(tt.args)
+		if !reflect.DeepEqual(flags, tt.flags) || !reflect.DeepEqual(command, tt.command) {
+			t.Errorf("splitSSHArgs(%q) = %q, %q, want %q, %q", tt.args, flags, command, tt.flags, tt.command)
+		}
+	}
+}
+
+func TestMergeSSHArgs(t *testing.T) {
+	tests := []struct {
+		defaults []string
+		args     []string
+		flags    []string
+		command  []string
+	}{
+		{nil, nil, nil, nil},
+		{[]string{"-A"}, nil, []string{"-A"}, nil},
+		{nil, []string{"-A", "uptime"}, []string{"-A"}, []string{"uptime"}},
+		{[]string{"-A", "htop"}, []string{"-L", "8080:localhost:80"}, []string{"-A", "-L", "8080:localhost:80"}, []string{"htop"}},
+		{[]string{"-A", "htop"}, []string{"uptime"}, []string{"-A"}, []string{"uptime"}},
+		{[]string{"-l", "root"}, []string{"-l", "deploy"}, []string{"-l", "root", "-l", "deploy"}, nil},
+	}
+
+	for _, tt := range tests {
+		flags, command := mergeSSHArgs(tt.defaults, tt.args)
+		if !reflect.DeepEqual(flags, tt.flags) || !reflect.DeepEqual(command, tt.command) {
+			t.Errorf("mergeSSHArgs(%q, %q) = %q, %q, want %q, %q", tt.defaults, tt.args, flags, command, tt.flags, tt.command)
+		}
+	}
+}
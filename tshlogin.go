@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Firebain/tssh/expect"
+	"github.com/creack/pty"
+)
+
+const (
+	tshPromptTimeout = 30 * time.Second
+	tshLoginAttempts = 3
+
+	// otpSafetyMargin keeps codes that are about to roll over from being
+	// submitted, tsh needs a moment to send them to the proxy.
+	otpSafetyMargin = 2 * time.Second
+)
+
+var errOTPRejected = errors.New("OTP code rejected")
+
+// otpRejectedError matches the tsh errors blaming the OTP code.
+var otpRejectedError = regexp.MustCompile(`(?i)(invalid|expired|incorrect|rejected|already used)[^\n]*\b(t?otp|one[- ]time)\b|\b(t?otp|one[- ]time)\b[^\n]*(invalid|expired|incorrect|rejected|already used)`)
+
+// otpRejected tells whether the tsh error clearly says the OTP code was
+// rejected. An error that may be about the password is not retried, that would
+// only use up the failed logins Teleport allows before locking the user.
+func otpRejected(message string) bool {
+	return otpRejectedError.MatchString(message) && !strings.Contains(strings.ToLower(message), "password")
+}
+
+// Known 'tsh login' prompts. The error prompt is listed first so a failure
+// printed right before the next prompt is never answered.
+var (
+	tshErrorPrompt    = expect.NewPrompt("error", `(?i)(ERROR:|invalid credentials|access denied)[^\n]*\n`)
+	tshOTPPrompt      = expect.NewPrompt("otp", `(?i)(otp|one-time|code from)[^\n]*:\s*$`)
+	tshPasswordPrompt = expect.NewPrompt("password", `(?i)password[^\n]*:\s*$`)
+	tshTapPrompt      = expect.NewPrompt("hardware key", `(?i)tap [^\n]*key[^\n]*$`)
+)
+
+// TshLogin runs 'tsh login' in a pty and answers its prompts with auth. When
+// tsh says the OTP code was rejected it waits for the next OTP period and tries again,
+// up to tshLoginAttempts times. It returns the number of attempts made.
+// Cancelling ctx kills the running 'tsh login'.
+func TshLogin(ctx context.Context, p Profile, auth *Auth) (int, error) {
+	var err error
+
+	for attempt := 1; attempt <= tshLoginAttempts; attempt++ {
+		err = tshLoginAttempt(ctx, p, auth)
+		if ctx.Err() != nil {
+			return attempt, ctx.Err()
+		}
+		if !errors.Is(err, errOTPRejected) {
+			return attempt, err
+		}
+
+		sleepErr := sleep(ctx, time.Until(nextOTPPeriod(time.Now(), auth.OTPPeriod())))
+		if sleepErr != nil {
+			return attempt, sleepErr
+		}
+	}
+
+	return tshLoginAttempts, fmt.Errorf("gave up after %d attempts: %w", tshLoginAttempts, err)
+}
+
+func tshLoginAttempt(ctx context.Context, p Profile, auth *Auth) error {
+	c := exec.CommandContext(ctx, tshBinary, append([]string{"login"}, p.TshArgs()...)...)
+
+	f, err := pty.Start(c)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	session := expect.New(f)
+
+	err = answerTshPrompts(ctx, session, p, auth)
+	if err == nil {
+		err = c.Wait()
+	} else {
+		c.Process.Kill()
+		c.Wait()
+	}
+
+	if err != nil {
+		return saveTshTranscript(session, err)
+	}
+
+	return nil
+}
+
+func answerTshPrompts(ctx context.Context, session *expect.Session, p Profile, auth *Auth) error {
+	passwordSent := false
+	codeSent := false
+
+	for {
+		prompt, match, err := session.Expect(tshPromptTimeout, tshErrorPrompt, tshOTPPrompt, tshPasswordPrompt, tshTapPrompt)
+		if errors.Is(err, expect.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch prompt.Name {
+		case tshPasswordPrompt.Name:
+			if passwordSent {
+				return errors.New("tsh asked for the password again, check the stored password")
+			}
+
+			err = session.Send("password", auth.Password)
+			if err != nil {
+				return err
+			}
+
+			passwordSent = true
+		case tshOTPPrompt.Name:
+			if codeSent {
+				return errors.New("tsh asked for the OTP code again, check the stored OTP secret")
+			}
+
+			at := otpCodeTime(time.Now(), lastOTPCodeTime(p), auth.OTPPeriod())
+			err = sleep(ctx, time.Until(at))
+			if err != nil {
+				return err
+			}
+
+			code, err := auth.Code(at)
+			if err != nil {
+				return err
+			}
+
+			storeLastOTPCodeTime(p, at)
+
+			err = session.Send("otp code", code)
+			if err != nil {
+				return err
+			}
+
+			codeSent = true
+		case tshTapPrompt.Name:
+			return errors.New("tsh asked to tap a hardware key, automatic login supports only password and OTP")
+		case tshErrorPrompt.Name:
+			// Only a rejected code is worth waiting for the next one, any
+			// other failure ends the login.
+			if codeSent && otpRejected(match) {
+				return fmt.Errorf("tsh login failed: %w: %s", errOTPRejected, strings.TrimSpace(match))
+			}
+
+			return fmt.Errorf("tsh login failed: %s", strings.TrimSpace(match))
+		}
+	}
+}
+
+// saveTshTranscript keeps the failed 'tsh login' conversation next to the
+// cache for debugging and mentions it in err.
+func saveTshTranscript(session *expect.Session, err error) error {
+	cacheDir, dirErr := GetCacheDir()
+	if dirErr != nil {
+		return err
+	}
+
+	dirErr = CreateCachePath()
+	if dirErr != nil {
+		return err
+	}
+
+	path := filepath.Join(cacheDir, "tsh-login.log")
+
+	writeErr := os.WriteFile(path, []byte(session.Transcript()), 0600)
+	if writeErr != nil {
+		return err
+	}
+
+	return fmt.Errorf("%w (transcript saved to %s)", err, path)
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func otpCounter(t time.Time, period time.Duration) int64 {
+	return t.Unix() / int64(period/time.Second)
+}
+
+func nextOTPPeriod(t time.Time, period time.Duration) time.Time {
+	return time.Unix((otpCounter(t, period)+1)*int64(period/time.Second), 0)
+}
+
+// otpCodeTime returns when the code should be generated and submitted: now,
+// or the start of the next period when the current code is about to expire
+// or was already used by the previous login, whose code was generated at last.
+func otpCodeTime(now time.Time, last time.Time, period time.Duration) time.Time {
+	next := nextOTPPeriod(now, period)
+
+	if next.Sub(now) < otpSafetyMargin || otpCounter(now, period) <= otpCounter(last, period) {
+		return next
+	}
+
+	return now
+}
+
+func lastOTPCodePath(p Profile) (string, error) {
+	cachePath, err := GetCachePath(p)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(cachePath), "otp-last-used"), nil
+}
+
+// lastOTPCodeTime returns when the last code submitted for p was generated,
+// Teleport refuses to accept the same code twice.
+func lastOTPCodeTime(p Profile) time.Time {
+	path, err := lastOTPCodePath(p)
+	if err != nil {
+		return time.Time{}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	last, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}
+	}
+
+	return last
+}
+
+func storeLastOTPCodeTime(p Profile, t time.Time) {
+	path, err := lastOTPCodePath(p)
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
+	if err != nil {
+		return
+	}
+
+	os.WriteFile(path, []byte(t.UTC().Format(time.RFC3339)), 0600)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTsh answers like 'tsh login': it asks for the password and the OTP
+// code, records them and ends as FAKE_TSH_RESULT says.
+const fakeTsh = `#!/bin/sh
+stty -echo 2>/dev/null
+echo "$@" >> "$FAKE_TSH_DIR/calls"
+printf 'Enter password for Teleport user alice: '
+read -r password
+printf 'Enter an OTP code from a device: '
+read -r code
+echo "$password $code" >> "$FAKE_TSH_DIR/answers"
+case "$FAKE_TSH_RESULT" in
+ok)
+	echo "> Profile URL: https://teleport.example.com:443"
+	exit 0
+	;;
+hang)
+	read -r never
+	;;
+*)
+	echo "ERROR: $FAKE_TSH_RESULT"
+	exit 1
+	;;
+esac
+`
+
+// useFakeTsh makes TshLogin run fakeTsh, ending with result, and keeps the
+// cache in a temporary dir.
+func useFakeTsh(t *testing.T, result string) string {
+	t.Helper()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("tsh login is driven through a pty")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tsh")
+
+	err := os.WriteFile(path, []byte(fakeTsh), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binary := tshBinary
+	tshBinary = path
+	t.Cleanup(func() { tshBinary = binary })
+
+	t.Setenv("FAKE_TSH_DIR", dir)
+	t.Setenv("FAKE_TSH_RESULT", result)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	return dir
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestTshLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   string
+		period   uint
+		attempts int
+		wantErr  string
+		rejected bool
+	}{
+		{
+			name:     "logged in",
+			result:   "ok",
+			attempts: 1,
+		},
+		{
+			name:     "wrong password is not retried",
+			result:   "access denied: invalid username, password or second factor",
+			attempts: 1,
+			wantErr:  "tsh login failed",
+		},
+		{
+			name:     "rejected code is retried",
+			result:   "invalid totp token",
+			period:   1,
+			attempts: tshLoginAttempts,
+			wantErr:  "gave up after 3 attempts",
+			rejected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useFakeTsh(t, tt.result)
+
+			p := Profile{Name: "teleport.example.com", Proxy: "teleport.example.com:443", User: "alice"}
+			auth := &Auth{Password: "hunter2", Secret: "JBSWY3DPEHPK3PXP", Period: tt.period}
+
+			attempts, err := TshLogin(context.Background(), p, auth)
+			if attempts != tt.attempts {
+				t.Errorf("TshLogin() attempts = %d, want %d", attempts, tt.attempts)
+			}
+
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("TshLogin() error = %v", err)
+			}
+
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("TshLogin() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if errors.Is(err, errOTPRejected) != tt.rejected {
+				t.Errorf("TshLogin() error = %v, rejected code = %v", err, tt.rejected)
+			}
+
+			calls := readLines(t, filepath.Join(dir, "calls"))
+			if len(calls) != tt.attempts {
+				t.Errorf("tsh ran %d times, want %d", len(calls), tt.attempts)
+			}
+
+			if calls[0] != "login --proxy=teleport.example.com:443 --user=alice" {
+				t.Errorf("tsh arguments = %q", calls[0])
+			}
+
+			// Every attempt gets a fresh code, the last one is remembered.
+			answers := readLines(t, filepath.Join(dir, "answers"))
+			codes := map[string]bool{}
+			for _, answer := range answers {
+				password, code, _ := strings.Cut(answer, " ")
+				if password != "hunter2" {
+					t.Errorf("tsh got password %q", password)
+				}
+
+				if codes[code] {
+					t.Errorf("code %s was sent twice", code)
+				}
+				codes[code] = true
+			}
+
+			last, err := auth.Code(lastOTPCodeTime(p))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !codes[last] {
+				t.Errorf("last code %s was not sent, got %v", last, answers)
+			}
+		})
+	}
+}
+
+func TestTshLoginCancel(t *testing.T) {
+	useFakeTsh(t, "hang")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	start := time.Now()
+
+	_, err := TshLogin(ctx, Profile{Name: "teleport.example.com"}, &Auth{Password: "hunter2", Secret: "JBSWY3DPEHPK3PXP"})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("TshLogin() error = %v, want %v", err, context.Canceled)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("TshLogin() returned after %s", elapsed)
+	}
+}
+
+func TestOTPRejected(t *testing.T) {
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"ERROR: invalid totp token\n", true},
+		{"ERROR: OTP code has expired\n", true},
+		{"ERROR: access denied: invalid username, password or second factor\n", false},
+		{"ERROR: invalid credentials\n", false},
+		{"ERROR: invalid password or OTP\n", false},
+		{"ERROR: invalid response, status code 403\n", false},
+	}
+
+	for _, tt := range tests {
+		if got := otpRejected(tt.message); got != tt.want {
+			t.Errorf("otpRejected(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestOTPCodeTime(t *testing.T) {
+	period := 30 * time.Second
+	start := time.Unix(1_700_000_010, 0) // the start of a period
+	next := start.Add(period)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		last time.Time
+		want time.Time
+	}{
+		{
+			name: "no code used yet",
+			now:  start.Add(5 * time.Second),
+			want: start.Add(5 * time.Second),
+		},
+		{
+			name: "code used in an earlier period",
+			now:  start.Add(5 * time.Second),
+			last: start.Add(-time.Second),
+			want: start.Add(5 * time.Second),
+		},
+		{
+			name: "code of this period already used",
+			now:  start.Add(5 * time.Second),
+			last: start,
+			want: next,
+		},
+		{
+			name: "code about to expire",
+			now:  next.Add(-time.Second),
+			want: next,
+		},
+		{
+			name: "code expiring after the margin",
+			now:  next.Add(-otpSafetyMargin),
+			want: next.Add(-otpSafetyMargin),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := otpCodeTime(tt.now, tt.last, period)
+			if !got.Equal(tt.want) {
+				t.Errorf("otpCodeTime() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gravitational/teleport/api/client"
+	"github.com/gravitational/teleport/api/profile"
+)
+
+// tshBinary is the tsh executable tssh runs, tests replace it with a fake.
+var tshBinary = "tsh"
+
+// Profile is a tsh profile: a proxy together with the Teleport user logged
+// in to it. Auth, the server cache and the selected logins are kept per
+// Profile.
+type Profile struct {
+	Name  string
+	Proxy string
+	User  string
+}
+
+// LoadProfile returns the tsh profile for proxy, or the current one when
+// proxy is empty. A proxy without a profile yet gets an empty User, tsh
+// creates the profile on the first login.
+func LoadProfile(proxy string) (Profile, error) {
+	dir := profile.FullProfilePath("")
+
+	name := proxy
+	if host, _, err := net.SplitHostPort(proxy); err == nil {
+		name = host
+	}
+
+	if name == "" {
+		current, err := profile.GetCurrentProfileName(dir)
+		if err != nil {
+			return Profile{}, err
+		}
+
+		name = current
+	}
+
+	p, err := profile.FromDir(dir, name)
+	if err != nil {
+		if proxy != "" {
+			return Profile{Name: name, Proxy: proxy}, nil
+		}
+
+		return Profile{}, err
+	}
+
+	return Profile{
+		Name:  name,
+		Proxy: p.WebProxyAddr,
+		User:  p.Username,
+	}, nil
+}
+
+func ListProfiles() ([]Profile, error) {
+	names, err := profile.ListProfileNames(profile.FullProfilePath(""))
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(names)
+
+	profiles := make([]Profile, 0, len(names))
+	for _, name := range names {
+		p, err := LoadProfile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, p)
+	}
+
+	return profiles, nil
+}
+
+func (p Profile) Credentials() client.Credentials {
+	return client.LoadProfile("", p.Name)
+}
+
+// Key identifies the profile in cache paths and secret store accounts. It is
+// the proxy name alone until the first login tells the user, see
+// MigrateProfileState.
+func (p Profile) Key() string {
+	key := p.Name
+	if p.User != "" {
+		key = p.User + "@" + p.Name
+	}
+
+	return strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(key)
+}
+
+// MigrateProfileState hands what was kept for the proxy of p before its user
+// was known over to p: the servers cache, the favourites and history, and
+// the stored auth. Whatever p already has is kept.
+func MigrateProfileState(p Profile) error {
+	if p.User == "" {
+		return nil
+	}
+
+	proxy := Profile{Name: p.Name, Proxy: p.Proxy}
+
+	for _, path := range []func(Profile) (string, error){GetCachePath, GetFavouritesPath} {
+		from, err := path(proxy)
+		if err != nil {
+			return err
+		}
+
+		to, err := path(p)
+		if err != nil {
+			return err
+		}
+
+		err = moveMissing(filepath.Dir(from), filepath.Dir(to))
+		if err != nil {
+			return err
+		}
+	}
+
+	fromFile, err := NewFileStore(proxy.Key())
+	if err != nil {
+		return err
+	}
+
+	toFile, err := NewFileStore(p.Key())
+	if err != nil {
+		return err
+	}
+
+	if fromFile.Exists() && !toFile.Exists() {
+		err = fromFile.Move(toFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	return moveAuth(NewSystemAuthStore(proxy.Key()), NewSystemAuthStore(p.Key()))
+}
+
+// moveMissing renames from to to, unless to exists or from doesn't.
+func moveMissing(from string, to string) error {
+	_, err := os.Stat(to)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	_, err = os.Stat(from)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(to), os.ModeDir|0755)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(from, to)
+}
+
+func moveAuth(from AuthStore, to AuthStore) error {
+	auth, err := from.Get()
+	if err != nil || auth == nil {
+		return err
+	}
+
+	existing, err := to.Get()
+	if err != nil || existing != nil {
+		return err
+	}
+
+	err = to.Store(*auth)
+	if err != nil {
+		return err
+	}
+
+	return from.Delete()
+}
+
+// IsCurrent tells whether p is the profile tsh uses by default.
+func (p Profile) IsCurrent() bool {
+	current, err := profile.GetCurrentProfileName(profile.FullProfilePath(""))
+
+	return err == nil && current == p.Name
+}
+
+func (p Profile) String() string {
+	if p.User == "" {
+		return p.Name
+	}
+
+	return p.User + "@" + p.Name
+}
+
+// TshArgs selects the profile in tsh commands.
+func (p Profile) TshArgs() []string {
+	if p.Proxy == "" {
+		return nil
+	}
+
+	args := []string{"--proxy=" + p.Proxy}
+	if p.User != "" {
+		args = append(args, "--user="+p.User)
+	}
+
+	return args
+}
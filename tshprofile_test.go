@@ -0,0 +1,128 @@
+package main
+
+import "testing"
+
+// storeFileAuth stores auth for p in the encrypted file, the OS keyring is
+// not there in tests.
+func storeFileAuth(t *testing.T, p Profile, auth Auth) {
+	t.Helper()
+
+	store, err := NewFileStore(p.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Store(auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfileKey(t *testing.T) {
+	tests := []struct {
+		profile Profile
+		want    string
+	}{
+		{Profile{Name: "teleport.example.com"}, "teleport.example.com"},
+		{Profile{Name: "teleport.example.com", User: "alice"}, "alice@teleport.example.com"},
+		{Profile{Name: "teleport.example.com", User: "corp\\alice"}, "corp_alice@teleport.example.com"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.profile.Key(); got != tt.want {
+			t.Errorf("%+v.Key() = %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+func TestMigrateProfileState(t *testing.T) {
+	useTempHome(t, "")
+	t.Setenv("TSSH_PASSPHRASE", "correct horse")
+
+	proxy := Profile{Name: "teleport.example.com"}
+	alice := Profile{Name: "teleport.example.com", User: "alice"}
+	bob := Profile{Name: "teleport.example.com", User: "bob"}
+
+	err := StoreServersInfo(proxy, &ServersInfo{DefaultLogin: "root"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = StoreFavourites(proxy, &Favourites{Entries: []Favourite{{Hostname: "web-1", Alias: "w"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storeFileAuth(t, proxy, Auth{Password: "hunter2", Secret: "JBSWY3DPEHPK3PXP"})
+
+	err = MigrateProfileState(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := GetServersInfoFromCache(alice)
+	if err != nil || info.DefaultLogin != "root" {
+		t.Errorf("servers of alice = %+v, %v", info, err)
+	}
+
+	favourites, err := LoadFavourites(alice)
+	if err != nil || len(favourites.Entries) != 1 {
+		t.Errorf("favourites of alice = %+v, %v", favourites, err)
+	}
+
+	auth, err := GetAuth(alice)
+	if err != nil || auth == nil || auth.Password != "hunter2" {
+		t.Errorf("auth of alice = %+v, %v", auth, err)
+	}
+
+	// Nothing is left for the proxy alone, so another user gets none of it.
+	err = MigrateProfileState(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []Profile{proxy, bob} {
+		if _, err := GetServersInfoFromCache(p); err == nil {
+			t.Errorf("%s still has servers", p)
+		}
+
+		if auth, err := GetAuth(p); err != nil || auth != nil {
+			t.Errorf("auth of %s = %+v, %v", p, auth, err)
+		}
+	}
+}
+
+func TestMigrateProfileStateKeepsUserState(t *testing.T) {
+	useTempHome(t, "")
+	t.Setenv("TSSH_PASSPHRASE", "correct horse")
+
+	proxy := Profile{Name: "teleport.example.com"}
+	alice := Profile{Name: "teleport.example.com", User: "alice"}
+
+	for _, state := range []struct {
+		p     Profile
+		login string
+	}{{proxy, "root"}, {alice, "alice"}} {
+		err := StoreServersInfo(state.p, &ServersInfo{DefaultLogin: state.login})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		storeFileAuth(t, state.p, Auth{Password: state.login})
+	}
+
+	err := MigrateProfileState(alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := GetServersInfoFromCache(alice)
+	if err != nil || info.DefaultLogin != "alice" {
+		t.Errorf("servers of alice = %+v, %v", info, err)
+	}
+
+	auth, err := GetAuth(alice)
+	if err != nil || auth == nil || auth.Password != "alice" {
+		t.Errorf("auth of alice = %+v, %v", auth, err)
+	}
+}